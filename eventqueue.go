@@ -1,8 +1,10 @@
 package eventqueue
 
 import (
-	"container/heap"
-	"sync"
+	"io"
+	"time"
+
+	"github.com/elgris/eventqueue/generic"
 )
 
 // EventQueue allows to process out-of-order incoming events in
@@ -11,8 +13,8 @@ import (
 // starts emitting the events through output channel.
 // See NewEventQueue for details about instantiating EventQueue.
 //
-// Algorithm complexity of adding the event is O(logN).
-// Algorithm complexity of popping the event out is also O(logN)
+// EventQueue is generic.EventQueue for events of any type, see its documentation
+// for the methods and concurrency guarantees.
 //
 // Use case:
 // You have a stream of events that arrive out of the order within some window
@@ -22,13 +24,39 @@ import (
 // EventQueue simplifies this processing, especially when window size is not constant
 // (but limited by some constant number which can be used as "emitThreshold" in EventQueue)
 // and events arrive as an infinite stream and there is no clear separation between windows
-type EventQueue struct {
-	emitThreshold int
-	output        chan<- interface{}
-	queue         eventPriorityQueue
+type EventQueue = generic.EventQueue[interface{}]
 
-	lock sync.Mutex
-}
+// Stats holds runtime metrics of EventQueue, see generic.Stats
+type Stats = generic.Stats
+
+// Status holds the lifecycle state of EventQueue, see generic.Status
+type Status = generic.Status
+
+// ErrClosed is returned when an event is pushed to a closed queue
+var ErrClosed = generic.ErrClosed
+
+// ErrFull is returned when an event is dropped because the queue is full, see WithMaxSize
+var ErrFull = generic.ErrFull
+
+// ErrLate is returned when an event sorts before an event emitted already, see WithMonotonicEmission
+var ErrLate = generic.ErrLate
+
+// ErrCanceled is returned when ctx is done first, it wraps the error of ctx too,
+// see generic.ErrCanceled
+var ErrCanceled = generic.ErrCanceled
+
+// ErrComparatorPanic is reported when the comparator panics, see WithComparatorRecovery
+var ErrComparatorPanic = generic.ErrComparatorPanic
+
+// ErrInvalidThreshold is returned when emitThreshold is not positive, see EventQueue.SetEmitThreshold
+var ErrInvalidThreshold = generic.ErrInvalidThreshold
+
+// Sink takes emitted events without blocking, see generic.Sink and NewEventQueueSink
+type Sink = generic.Sink[interface{}]
+
+// Delivery is an event emitted by NewAckEventQueue with the ID to acknowledge it with,
+// see generic.Delivery
+type Delivery = generic.Delivery[interface{}]
 
 // Comparator is an entity that helps to sort incoming events.
 // Expect "a" anb "b" be events, so cast them to appropriate event type
@@ -43,67 +71,75 @@ type ComparatorFunc func(a, b interface{}) bool
 
 func (f ComparatorFunc) Less(a, b interface{}) bool { return f(a, b) }
 
+// CompositeComparator sorts events by the first comparator, each next one only breaks
+// the ties of the ones before it, see generic.CompositeComparator
+func CompositeComparator(comparators ...Comparator) Comparator {
+	composed := make([]generic.Comparator[interface{}], len(comparators))
+	for i, comparator := range comparators {
+		composed[i] = comparator
+	}
+	return generic.CompositeComparator[interface{}](composed...)
+}
+
+// SequenceFunc returns the monotonic sequence number of an event, see WithSequence
+type SequenceFunc func(interface{}) uint64
+
 // NewEventQueue creates EventQueue
 // - emitThreshold - this value sets number of events to accumulate before they start
-// emitting through output channel (see Channel() method)
+// emitting through output channel
 // - outputChannel - is an INPUT channel which will receive emitted events. The channel is injected
-// by a client of EventQueue and controlled by it
+// by a client of EventQueue and controlled by it. A nil channel makes the queue pull-only,
+// see generic.NewEventQueue
 // - comparator helps to sort your events
-func NewEventQueue(emitThreshold int, outputChannel chan<- interface{}, comparator Comparator) *EventQueue {
-	return &EventQueue{
-		emitThreshold: emitThreshold,
-		output:        outputChannel,
-
-		queue: eventPriorityQueue{
-			data:       make([]interface{}, 0, 10),
-			comparator: comparator,
-		},
-	}
+// - options tune optional behavior of the queue, see With* functions
+func NewEventQueue(emitThreshold int, outputChannel chan<- interface{}, comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewEventQueue[interface{}](emitThreshold, outputChannel, comparator, options...)
 }
 
-// Push adds an event to the queue in an ordered matter
-func (es *EventQueue) Push(item interface{}) {
-	es.lock.Lock()
-	defer es.lock.Unlock()
+// NewBatchEventQueue creates EventQueue that sends emitted events to outputChannel
+// as sorted slices instead of one by one, see generic.NewBatchEventQueue
+func NewBatchEventQueue(emitThreshold int, outputChannel chan<- []interface{}, comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewBatchEventQueue[interface{}](emitThreshold, outputChannel, comparator, options...)
+}
 
-	heap.Push(&es.queue, item)
-	if es.queue.Len() >= es.emitThreshold {
-		es.output <- es.popUnprotected()
-	}
+// NewEventQueueFunc creates EventQueue that emits events by calling sink instead
+// of sending them to a channel, see generic.NewEventQueueFunc
+func NewEventQueueFunc(emitThreshold int, sink func(interface{}), comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewEventQueueFunc[interface{}](emitThreshold, sink, comparator, options...)
 }
 
-// Flush pushes the rest of the aggregated events to output channel.
-// At the end the queue is empty
-func (es *EventQueue) Flush() {
-	es.lock.Lock()
-	defer es.lock.Unlock()
+// NewEventQueueErrFunc creates EventQueue that emits events by calling sink that may fail,
+// see generic.NewEventQueueErrFunc
+func NewEventQueueErrFunc(emitThreshold int, sink func(interface{}) error, comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewEventQueueErrFunc[interface{}](emitThreshold, sink, comparator, options...)
+}
 
-	l := es.Len()
-	for i := 0; i < l; i++ {
-		es.output <- es.popUnprotected()
-	}
+// NewEventQueueWriter creates EventQueue that writes the emitted events to w in order,
+// each one encoded by encode, see generic.NewEventQueueWriter
+func NewEventQueueWriter(emitThreshold int, w io.Writer, encode func(interface{}) ([]byte, error), comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewEventQueueWriter[interface{}](emitThreshold, w, encode, comparator, options...)
 }
 
-// Len returns current length of the queue
-func (es *EventQueue) Len() int { return es.queue.Len() }
+// NewEventQueueSink creates EventQueue that emits events into sink, e.g. a lock-free
+// ring buffer, instead of a channel, see generic.NewEventQueueSink
+func NewEventQueueSink(emitThreshold int, sink Sink, comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewEventQueueSink[interface{}](emitThreshold, sink, comparator, options...)
+}
 
-func (es *EventQueue) popUnprotected() interface{} { return heap.Pop(&es.queue) }
+// NewEventQueueFromSlice creates EventQueue that buffers the items at once in O(n),
+// see generic.NewEventQueueFromSlice
+func NewEventQueueFromSlice(items []interface{}, emitThreshold int, outputChannel chan<- interface{}, comparator Comparator, options ...Option) *EventQueue {
+	return generic.NewEventQueueFromSlice[interface{}](items, emitThreshold, outputChannel, comparator, options...)
+}
 
-// eventPriorityQueue is just an implementation of PriorityQueue (MinHeap) for events
-type eventPriorityQueue struct {
-	data       []interface{}
-	comparator Comparator
+// NewAckEventQueue creates EventQueue that emits events at least once: the events
+// not acknowledged with Ack within visibility are sent again, see generic.NewAckEventQueue
+func NewAckEventQueue(emitThreshold int, outputChannel chan<- Delivery, comparator Comparator, visibility time.Duration, options ...Option) *EventQueue {
+	return generic.NewAckEventQueue[interface{}](emitThreshold, outputChannel, comparator, visibility, options...)
 }
 
-func (pq eventPriorityQueue) Len() int           { return len(pq.data) }
-func (pq eventPriorityQueue) Less(i, j int) bool { return pq.comparator.Less(pq.data[i], pq.data[j]) }
-func (pq eventPriorityQueue) Swap(i, j int)      { pq.data[i], pq.data[j] = pq.data[j], pq.data[i] }
-
-func (pq *eventPriorityQueue) Push(x interface{}) { pq.data = append(pq.data, x) }
-func (pq *eventPriorityQueue) Pop() interface{} {
-	old := pq.data
-	n := len(old)
-	item := old[n-1]
-	pq.data = old[0 : n-1]
-	return item
+// NewReorderBuffer creates EventQueue that sorts events by timestamp and emits each one
+// once hold has passed since its timestamp, see generic.NewReorderBuffer
+func NewReorderBuffer(timestamp func(interface{}) time.Time, hold time.Duration, outputChannel chan<- interface{}, options ...Option) *EventQueue {
+	return generic.NewReorderBuffer[interface{}](timestamp, hold, outputChannel, options...)
 }
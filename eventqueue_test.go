@@ -1,7 +1,11 @@
 package eventqueue
 
 import (
+	"context"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -58,3 +62,697 @@ func TestEventChannelEmitting(t *testing.T) {
 		require.Equal(t, events[expectedIndex], item.(testEvent), "test case #%d", i)
 	}
 }
+
+var sequenceComparator ComparatorFunc = func(a, b interface{}) bool {
+	return a.(testEvent).sequence < b.(testEvent).sequence
+}
+
+func TestPushToClosedChannelPanics(t *testing.T) {
+	ch := make(chan interface{})
+	queue := NewEventQueue(1, ch, sequenceComparator)
+	close(ch)
+
+	require.Panics(t, func() { queue.Push(testEvent{sequence: 1}) })
+	// the queue is not left locked
+	require.Equal(t, 0, queue.Len())
+}
+
+func TestLenWhilePushing(t *testing.T) {
+	ch := make(chan interface{}, 100)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			queue.Push(testEvent{sequence: uint64(i)})
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			require.True(t, queue.Len() <= 10)
+		}
+	}
+	require.Equal(t, 9, queue.Len())
+}
+
+func TestPushContextCanceled(t *testing.T) {
+	ch := make(chan interface{})
+	queue := NewEventQueue(2, ch, sequenceComparator)
+	queue.Push(testEvent{sequence: 3})
+
+	for i, item := range []testEvent{{sequence: 1}, {sequence: 5}} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := queue.PushContext(ctx, item)
+		cancel()
+
+		require.ErrorIs(t, err, ErrCanceled, "test case #%d", i)
+		require.ErrorIs(t, err, context.DeadlineExceeded, "test case #%d", i)
+		require.Equal(t, 1, queue.Len(), "test case #%d", i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := queue.PushContext(ctx, testEvent{sequence: 4})
+	require.ErrorIs(t, err, ErrCanceled)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, queue.Len())
+
+	errs := make(chan error, 1)
+	go func() { errs <- queue.PushContext(context.Background(), testEvent{sequence: 6}) }()
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.NoError(t, <-errs)
+
+	// none of the canceled events made it to the queue
+	go func() {
+		queue.Flush()
+		close(ch)
+	}()
+	var rest []interface{}
+	for item := range ch {
+		rest = append(rest, item)
+	}
+	require.Equal(t, []interface{}{testEvent{sequence: 6}}, rest)
+}
+
+func TestTryPush(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	queue := NewEventQueue(2, ch, sequenceComparator)
+
+	require.True(t, queue.TryPush(testEvent{sequence: 3}))
+	require.True(t, queue.TryPush(testEvent{sequence: 2}))
+	require.Equal(t, 1, queue.Len())
+
+	// output channel is full, so nothing is emitted and the queue grows
+	require.False(t, queue.TryPush(testEvent{sequence: 1}))
+	require.False(t, queue.TryPush(testEvent{sequence: 4}))
+	require.Equal(t, 3, queue.Len())
+
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	require.True(t, queue.TryPush(testEvent{sequence: 5}))
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Equal(t, 3, queue.Len())
+}
+
+func TestClose(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(5, ch, sequenceComparator, WithOwnedOutput())
+
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Close()
+	queue.Close()
+
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.(testEvent).sequence)
+	}
+	require.Equal(t, []uint64{1, 2, 3}, emitted)
+	require.Equal(t, 0, queue.Len())
+	require.Panics(t, func() { queue.Push(testEvent{sequence: 4}) })
+}
+
+func TestCloseDoesNotCloseClientChannel(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(5, ch, sequenceComparator)
+
+	queue.Push(testEvent{sequence: 1})
+	queue.Close()
+
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	ch <- testEvent{sequence: 2}
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+}
+
+func TestLowWatermark(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(4, ch, sequenceComparator, WithLowWatermark(1))
+
+	for _, sequence := range []uint64{5, 3, 4} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Len(t, ch, 0)
+
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, 1, queue.Len())
+	for _, expected := range []uint64{1, 3, 4} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+
+	queue.Push(testEvent{sequence: 2})
+	require.Len(t, ch, 0)
+	require.Equal(t, 2, queue.Len())
+}
+
+func TestPushContextLowWatermark(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	queue := NewEventQueue(3, ch, sequenceComparator, WithLowWatermark(0))
+	queue.Push(testEvent{sequence: 4})
+	queue.Push(testEvent{sequence: 2})
+
+	// the first event fits into the channel, the rest stays in the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.NoError(t, queue.PushContext(ctx, testEvent{sequence: 3}))
+	require.Equal(t, 2, queue.Len())
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+
+	go queue.Flush()
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Equal(t, testEvent{sequence: 4}, <-ch)
+}
+
+func TestPopN(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+
+	for _, sequence := range []uint64{5, 1, 4, 2, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	items := queue.PopN(3)
+	require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 2}, testEvent{sequence: 3}}, items)
+	require.Equal(t, 2, queue.Len())
+
+	items = queue.PopN(10)
+	require.Equal(t, []interface{}{testEvent{sequence: 4}, testEvent{sequence: 5}}, items)
+	require.Nil(t, queue.PopN(1))
+	require.Len(t, ch, 0)
+}
+
+func TestPopUntil(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+
+	for _, sequence := range []uint64{5, 1, 4, 2, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	items := queue.PopUntil(testEvent{sequence: 3})
+	require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 2}}, items)
+	require.Nil(t, queue.PopUntil(testEvent{sequence: 3}))
+	require.Equal(t, []interface{}{testEvent{sequence: 3}, testEvent{sequence: 4}, testEvent{sequence: 5}}, queue.PopUntil(testEvent{sequence: 10}))
+	require.Len(t, ch, 0)
+}
+
+func TestSplitAt(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+
+	for _, sequence := range []uint64{5, 1, 4, 2, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	splitCh := make(chan interface{}, 10)
+	split := queue.SplitAt(testEvent{sequence: 3}, splitCh)
+	require.Equal(t, 2, queue.Len())
+	require.Equal(t, 3, split.Len())
+	require.Len(t, splitCh, 0)
+
+	split.Push(testEvent{sequence: 6})
+	split.Flush()
+	require.Len(t, splitCh, 4)
+	for _, expected := range []uint64{3, 4, 5, 6} {
+		require.Equal(t, testEvent{sequence: expected}, <-splitCh)
+	}
+
+	queue.Push(testEvent{sequence: 0})
+	queue.Flush()
+	for _, expected := range []uint64{0, 1, 2} {
+		require.Equal(t, testEvent{sequence: expected}, <-ch)
+	}
+
+	// without an output the split queue is pull-only
+	for _, sequence := range []uint64{7, 2, 9, 1, 8} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	pull := queue.SplitAt(testEvent{sequence: 8}, nil)
+	pull.Flush()
+	require.Len(t, ch, 0)
+	require.Equal(t, []interface{}{testEvent{sequence: 8}, testEvent{sequence: 9}}, pull.Drain())
+	require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 2}, testEvent{sequence: 7}}, queue.Drain())
+	require.Zero(t, queue.SplitAt(testEvent{sequence: 0}, nil).Len())
+}
+
+func TestCountIf(t *testing.T) {
+	queue := NewEventQueue(10, nil, sequenceComparator)
+	require.Zero(t, queue.CountIf(func(interface{}) bool { return true }))
+
+	for sequence := uint64(1); sequence <= 5; sequence++ {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	even := func(item interface{}) bool { return item.(testEvent).sequence%2 == 0 }
+	require.Equal(t, 2, queue.CountIf(even))
+	require.Equal(t, 5, queue.Len(), "nothing is drained")
+}
+
+func TestMerge(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	other := NewEventQueue(10, nil, sequenceComparator)
+
+	for _, sequence := range []uint64{5, 1, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	for _, sequence := range []uint64{4, 2, 6} {
+		other.Push(testEvent{sequence: sequence})
+	}
+
+	queue.Merge(other)
+	queue.Merge(queue)
+	require.Equal(t, 6, queue.Len())
+	require.Zero(t, other.Len())
+
+	// merging into each other at once does not deadlock
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); queue.Merge(other) }()
+	go func() { defer wg.Done(); other.Merge(queue) }()
+	wg.Wait()
+	require.Equal(t, 6, queue.Len()+other.Len())
+
+	queue.Merge(other)
+	queue.Flush()
+	for sequence := uint64(1); sequence <= 6; sequence++ {
+		require.Equal(t, testEvent{sequence: sequence}, <-ch)
+	}
+}
+
+func TestPushErrClosed(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(2, ch, sequenceComparator)
+
+	require.NoError(t, queue.PushErr(testEvent{sequence: 1}))
+	queue.Close()
+
+	require.Equal(t, ErrClosed, queue.PushErr(testEvent{sequence: 2}))
+	require.Equal(t, ErrClosed, queue.PushContext(context.Background(), testEvent{sequence: 2}))
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Len(t, ch, 0)
+}
+
+func TestFlushContext(t *testing.T) {
+	ch := make(chan interface{}, 2)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	for _, sequence := range []uint64{4, 2, 3, 1} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	remaining, err := queue.FlushContext(ctx)
+	require.ErrorIs(t, err, ErrCanceled)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 2, remaining)
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+
+	remaining, err = queue.FlushContext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, remaining)
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Equal(t, testEvent{sequence: 4}, <-ch)
+}
+
+func TestDrain(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 2}, testEvent{sequence: 3}}, queue.Drain())
+	require.Equal(t, 0, queue.Len())
+	require.Nil(t, queue.Drain())
+	require.Len(t, ch, 0)
+}
+
+func TestWait(t *testing.T) {
+	ch := make(chan interface{})
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	queue.Push(testEvent{sequence: 1})
+
+	go queue.Close()
+	select {
+	case <-queue.Done():
+		t.Fatal("queue is drained before the event is read")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	queue.Wait()
+	queue.Wait()
+	<-queue.Done()
+}
+
+func TestSetComparator(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(4, ch, sequenceComparator)
+	for i, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence, content: i})
+	}
+
+	queue.SetComparator(ComparatorFunc(func(a, b interface{}) bool {
+		return a.(testEvent).content > b.(testEvent).content
+	}))
+	queue.Push(testEvent{sequence: 4, content: 3})
+	require.Equal(t, testEvent{sequence: 4, content: 3}, <-ch)
+	queue.Flush()
+	for _, expected := range []uint64{2, 1, 3} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+}
+
+func TestTryFlush(t *testing.T) {
+	ch := make(chan interface{}, 2)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	emitted, remaining := queue.TryFlush(10 * time.Millisecond)
+	require.Equal(t, 2, emitted)
+	require.Equal(t, 1, remaining)
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	require.Equal(t, []interface{}{testEvent{sequence: 3}}, queue.Drain())
+}
+
+func TestSetEmitThreshold(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 4, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, 0, len(ch))
+
+	require.Equal(t, ErrInvalidThreshold, queue.SetEmitThreshold(0))
+	require.NoError(t, queue.SetEmitThreshold(2))
+	for _, expected := range []uint64{1, 2, 3} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+	require.Equal(t, 1, queue.Len())
+
+	queue.Push(testEvent{sequence: 5})
+	require.Equal(t, uint64(4), (<-ch).(testEvent).sequence)
+}
+
+func TestNext(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	for _, expected := range []uint64{1, 2, 3} {
+		item, ok := queue.Next()
+		require.True(t, ok)
+		require.Equal(t, expected, item.(testEvent).sequence)
+	}
+	_, ok := queue.Next()
+	require.False(t, ok)
+	require.Len(t, ch, 0)
+}
+
+func TestFlushIf(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	for _, sequence := range []uint64{5, 2, 4, 1, 3, 6} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	even := func(item interface{}) bool { return item.(testEvent).sequence%2 == 0 }
+	require.Equal(t, 3, queue.FlushIf(even))
+	for _, expected := range []uint64{2, 4, 6} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+	require.Equal(t, 3, queue.Len())
+	require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 3}, testEvent{sequence: 5}}, queue.Snapshot())
+	require.Equal(t, 0, queue.FlushIf(even))
+}
+
+func TestFlushIfPullOnly(t *testing.T) {
+	queue := NewEventQueue(10, nil, sequenceComparator)
+	for _, sequence := range []uint64{2, 1, 4} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	even := func(item interface{}) bool { return item.(testEvent).sequence%2 == 0 }
+	require.Equal(t, 0, queue.FlushIf(even))
+	require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 2}, testEvent{sequence: 4}}, queue.Drain())
+}
+
+func TestNilOutput(t *testing.T) {
+	encode := func(item interface{}) ([]byte, error) {
+		return []byte(strconv.Itoa(int(item.(testEvent).sequence))), nil
+	}
+	for name, queue := range map[string]*EventQueue{
+		"channel": NewEventQueue(1, nil, sequenceComparator),
+		"batch":   NewBatchEventQueue(1, nil, sequenceComparator),
+		"func":    NewEventQueueFunc(1, nil, sequenceComparator),
+		"errFunc": NewEventQueueErrFunc(1, nil, sequenceComparator),
+		"writer":  NewEventQueueWriter(1, nil, encode, sequenceComparator),
+		"sink":    NewEventQueueSink(1, nil, sequenceComparator),
+		"slice":   NewEventQueueFromSlice(nil, 1, nil, sequenceComparator),
+		"ack":     NewAckEventQueue(1, nil, sequenceComparator, time.Second),
+	} {
+		for _, sequence := range []uint64{2, 1} {
+			queue.Push(testEvent{sequence: sequence})
+		}
+		queue.Flush()
+		require.Equal(t, []interface{}{testEvent{sequence: 1}, testEvent{sequence: 2}}, queue.Drain(), name)
+		queue.Close()
+	}
+}
+
+func TestReorderBuffer(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	timestamp := func(item interface{}) time.Time { return item.(time.Time) }
+	queue := NewReorderBuffer(timestamp, 30*time.Millisecond, ch)
+	defer queue.Close()
+
+	now := time.Now()
+	queue.Push(now.Add(5 * time.Millisecond))
+	queue.Push(now)
+	queue.Push(now.Add(-time.Second))
+	require.Equal(t, now.Add(-time.Second), <-ch, "held long enough already")
+	select {
+	case <-ch:
+		t.Fatal("event is emitted before its hold time is over")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.Equal(t, now, <-ch)
+	require.Equal(t, now.Add(5*time.Millisecond), <-ch)
+}
+
+func TestReorderBufferSparse(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	timestamp := func(item interface{}) time.Time { return item.(time.Time) }
+	hold := 60 * time.Millisecond
+	queue := NewReorderBuffer(timestamp, hold, ch)
+	defer queue.Close()
+
+	queue.Push(time.Now().Add(-hold))
+	<-ch
+	// the hold counts from the timestamp of the event, not from the last emission
+	time.Sleep(hold / 2)
+	pushed := time.Now()
+	queue.Push(pushed)
+	require.Equal(t, pushed, <-ch)
+	require.GreaterOrEqual(t, time.Since(pushed), hold)
+}
+
+func TestNilOutputChannel(t *testing.T) {
+	queue := NewEventQueue(2, nil, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Flush()
+	require.Equal(t, 3, queue.Len())
+
+	item, ok := queue.Next()
+	require.True(t, ok)
+	require.Equal(t, testEvent{sequence: 1}, item)
+
+	queue.Close()
+	require.Equal(t, []interface{}{testEvent{sequence: 2}, testEvent{sequence: 3}}, queue.Drain())
+}
+
+func TestNewEventQueueFromSlice(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	items := []interface{}{testEvent{sequence: 4}, testEvent{sequence: 2}, testEvent{sequence: 3}, testEvent{sequence: 1}}
+	queue := NewEventQueueFromSlice(items, 3, ch, sequenceComparator)
+	require.Equal(t, 4, queue.Len())
+	require.Len(t, ch, 0)
+	require.Equal(t, testEvent{sequence: 4}, items[0], "items are copied")
+
+	queue.Push(testEvent{sequence: 5})
+	require.Equal(t, uint64(1), (<-ch).(testEvent).sequence)
+	queue.Flush()
+	for _, expected := range []uint64{2, 3, 4, 5} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(2, ch, sequenceComparator)
+	queue.Pause()
+	for _, sequence := range []uint64{4, 1, 3, 2} {
+		require.NoError(t, queue.PushErr(testEvent{sequence: sequence}))
+	}
+	require.Len(t, ch, 0)
+	require.Equal(t, 4, queue.Len())
+
+	queue.Resume()
+	for _, expected := range []uint64{1, 2, 3} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+	require.Equal(t, 1, queue.Len())
+
+	queue.Pause()
+	queue.Flush()
+	require.Equal(t, uint64(4), (<-ch).(testEvent).sequence)
+}
+
+func TestReset(t *testing.T) {
+	ch := make(chan interface{}, 100)
+	queue := NewEventQueue(100, ch, sequenceComparator, WithInitialCapacity(4))
+	for sequence := uint64(1); sequence <= 50; sequence++ {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Close()
+	require.Len(t, ch, 50)
+	_, capacity := queue.LenCap()
+
+	reset := make(chan interface{}, 10)
+	queue.Reset(reset, ComparatorFunc(func(a, b interface{}) bool {
+		return a.(testEvent).sequence > b.(testEvent).sequence
+	}))
+	require.Zero(t, queue.Stats().Pushed)
+	_, resetCapacity := queue.LenCap()
+	require.Equal(t, capacity, resetCapacity)
+
+	for _, sequence := range []uint64{1, 3, 2} {
+		require.NoError(t, queue.PushErr(testEvent{sequence: sequence}))
+	}
+	queue.Close()
+	for _, expected := range []uint64{3, 2, 1} {
+		require.Equal(t, expected, (<-reset).(testEvent).sequence)
+	}
+	require.Len(t, ch, 50)
+}
+
+func TestCompareAndPush(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(3, ch, sequenceComparator)
+	key := func(item interface{}) string { return strconv.Itoa(item.(testEvent).content) }
+	newer := func(existing, incoming interface{}) bool {
+		return incoming.(testEvent).sequence > existing.(testEvent).sequence
+	}
+
+	require.True(t, queue.CompareAndPush(testEvent{sequence: 2, content: 1}, key, newer))
+	require.True(t, queue.CompareAndPush(testEvent{sequence: 1, content: 2}, key, newer))
+	require.False(t, queue.CompareAndPush(testEvent{sequence: 1, content: 1}, key, newer))
+	require.True(t, queue.CompareAndPush(testEvent{sequence: 5, content: 1}, key, newer))
+	require.Equal(t, 2, queue.Len())
+	require.Equal(t, uint64(3), queue.Stats().Pushed)
+
+	require.True(t, queue.CompareAndPush(testEvent{sequence: 3, content: 3}, key, newer))
+	require.Equal(t, testEvent{sequence: 1, content: 2}, <-ch)
+	queue.Flush()
+	require.Equal(t, testEvent{sequence: 3, content: 3}, <-ch)
+	require.Equal(t, testEvent{sequence: 5, content: 1}, <-ch)
+}
+
+func TestCompareAndPushChecks(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(2, ch, sequenceComparator, WithMonotonicEmission(nil))
+	key := func(item interface{}) string { return strconv.Itoa(item.(testEvent).content) }
+	newer := func(existing, incoming interface{}) bool { return true }
+
+	require.True(t, queue.CompareAndPush(testEvent{sequence: 10, content: 1}, key, newer))
+	require.True(t, queue.CompareAndPush(testEvent{sequence: 11, content: 2}, key, newer))
+	require.Equal(t, testEvent{sequence: 10, content: 1}, <-ch)
+
+	// a late replacement is rejected like a late push and keeps the existing event
+	require.False(t, queue.CompareAndPush(testEvent{sequence: 5, content: 2}, key, newer))
+	require.Equal(t, []interface{}{testEvent{sequence: 11, content: 2}}, queue.Snapshot())
+	require.Equal(t, uint64(1), queue.Stats().Late)
+}
+
+func TestBounds(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	_, _, ok := queue.Bounds()
+	require.False(t, ok)
+
+	queue.Push(testEvent{sequence: 4})
+	min, max, ok := queue.Bounds()
+	require.True(t, ok)
+	require.Equal(t, testEvent{sequence: 4}, min)
+	require.Equal(t, testEvent{sequence: 4}, max)
+
+	for _, sequence := range []uint64{2, 7, 1, 5, 3, 6} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	min, max, ok = queue.Bounds()
+	require.True(t, ok)
+	require.Equal(t, testEvent{sequence: 1}, min)
+	require.Equal(t, testEvent{sequence: 7}, max)
+}
+
+func TestFlushComparator(t *testing.T) {
+	byContent := ComparatorFunc(func(a, b interface{}) bool {
+		return a.(testEvent).content < b.(testEvent).content
+	})
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(4, ch, sequenceComparator, WithFlushComparator(byContent))
+	for _, event := range []testEvent{{sequence: 1, content: 3}, {sequence: 4, content: 1}, {sequence: 2, content: 4}, {sequence: 3, content: 2}} {
+		queue.Push(event)
+	}
+	// in-stream emission goes by sequence
+	require.Equal(t, testEvent{sequence: 1, content: 3}, <-ch)
+
+	// the rest is flushed by content, which the sequence order diverges from
+	queue.Flush()
+	for _, expected := range []testEvent{{sequence: 4, content: 1}, {sequence: 3, content: 2}, {sequence: 2, content: 4}} {
+		require.Equal(t, expected, <-ch)
+	}
+}
+
+func TestEmitNow(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(10, ch, sequenceComparator)
+	require.False(t, queue.EmitNow())
+
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.True(t, queue.EmitNow())
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Len(t, ch, 0)
+	require.Equal(t, 2, queue.Len())
+
+	require.False(t, NewEventQueue(10, nil, sequenceComparator).EmitNow())
+}
+
+func TestWillEmitOnNextPush(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(3, ch, sequenceComparator)
+	queue.Push(testEvent{sequence: 1})
+	require.False(t, queue.WillEmitOnNextPush())
+	queue.Push(testEvent{sequence: 2})
+	require.True(t, queue.WillEmitOnNextPush())
+
+	queue.Pause()
+	require.False(t, queue.WillEmitOnNextPush())
+	queue.Resume()
+	queue.Push(testEvent{sequence: 3})
+	require.Len(t, ch, 1)
+	require.True(t, queue.WillEmitOnNextPush())
+}
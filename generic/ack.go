@@ -0,0 +1,163 @@
+package generic
+
+import (
+	"sync"
+	"time"
+)
+
+// Delivery is an event emitted by a queue created with NewAckEventQueue,
+// ID acknowledges the event, see EventQueue.Ack
+type Delivery[T any] struct {
+	ID    uint64
+	Event T
+}
+
+// acks keeps the emitted events in flight until they are acknowledged. The visibility
+// timeout is the same for all the events, so they expire in the order they are delivered.
+// It has its own lock, since the events are delivered with EventQueue lock released
+type acks[T any] struct {
+	lock       sync.Mutex
+	visibility time.Duration
+	nextID     uint64
+	inFlight   map[uint64]T
+	// order holds the IDs in delivery order, acknowledged ones are skipped when they expire
+	order []delivered
+	timer *time.Timer
+}
+
+type delivered struct {
+	id      uint64
+	expires time.Time
+}
+
+func newAcks[T any](visibility time.Duration) *acks[T] {
+	return &acks[T]{visibility: visibility, inFlight: make(map[uint64]T)}
+}
+
+// track puts the event in flight and returns its ID, redeliver is scheduled
+// once the event expires
+func (a *acks[T]) track(item T, redeliver func()) uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.nextID++
+	a.inFlight[a.nextID] = item
+	a.order = append(a.order, delivered{id: a.nextID, expires: time.Now().Add(a.visibility)})
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.visibility, redeliver)
+	} else if len(a.order) == 1 {
+		a.timer.Reset(a.visibility)
+	}
+	return a.nextID
+}
+
+// ack takes the event off the flight, it returns false if the event is not in flight
+func (a *acks[T]) ack(id uint64) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, ok := a.inFlight[id]; !ok {
+		return false
+	}
+	delete(a.inFlight, id)
+	return true
+}
+
+// expire takes the expired events off the flight, in delivery order,
+// and schedules the next check
+func (a *acks[T]) expire() []T {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var items []T
+	now := time.Now()
+	for len(a.order) > 0 && !a.order[0].expires.After(now) {
+		id := a.order[0].id
+		a.order = a.order[1:]
+		if item, ok := a.inFlight[id]; ok {
+			delete(a.inFlight, id)
+			items = append(items, item)
+		}
+	}
+	if len(a.order) > 0 {
+		a.timer.Reset(a.order[0].expires.Sub(now))
+	}
+	return items
+}
+
+func (a *acks[T]) stop() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+// ackSink sends events to a channel one by one wrapped in Delivery
+// and keeps them in flight until they are acknowledged
+type ackSink[T any] struct {
+	ch        chan<- Delivery[T]
+	acks      *acks[T]
+	redeliver func()
+}
+
+func (s ackSink[T]) send(items []T, done <-chan struct{}) int {
+	for i, item := range items {
+		id := s.acks.track(item, s.redeliver)
+		if !sendTo(s.ch, Delivery[T]{ID: id, Event: item}, done) {
+			s.acks.ack(id)
+			return i
+		}
+	}
+	return len(items)
+}
+
+func (s ackSink[T]) close() { close(s.ch) }
+
+// NewAckEventQueue creates EventQueue with at-least-once emission: each event is sent
+// to outputChannel as Delivery with an ID, which the consumer acknowledges with Ack once
+// the event is processed. An event that is not acknowledged within visibility is sent
+// again, right away and ahead of the events due later, so a redelivered event may arrive
+// after the events that sort after it. Close stops redelivery, the events in flight
+// by then are not sent again. WithSendTimeout has no effect on the queue.
+// A nil channel makes the queue pull-only like NewEventQueue does, nothing is in flight then
+func NewAckEventQueue[T any](emitThreshold int, outputChannel chan<- Delivery[T], comparator Comparator[T], visibility time.Duration, options ...Option[T]) *EventQueue[T] {
+	if outputChannel == nil {
+		return newEventQueue[T](emitThreshold, channelSink[T](nil), comparator, options)
+	}
+	a := newAcks[T](visibility)
+	s := &ackSink[T]{ch: outputChannel, acks: a}
+	es := newEventQueue[T](emitThreshold, s, comparator, options)
+	s.redeliver = func() { es.redeliver(a) }
+	es.acks = a
+	return es
+}
+
+// Ack acknowledges the event delivered with the given ID, so it is not sent again.
+// It returns false if the event is not in flight: it is acknowledged already, it expired
+// and is being sent again under a new ID, or the queue is not created by NewAckEventQueue
+func (es *EventQueue[T]) Ack(id uint64) bool {
+	if es.acks == nil {
+		return false
+	}
+	return es.acks.ack(id)
+}
+
+// redeliver is run by the timer of acks, it sends the expired events again
+func (es *EventQueue[T]) redeliver(a *acks[T]) {
+	items := a.expire()
+	if len(items) == 0 {
+		return
+	}
+
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.closed || es.acks != a {
+		// stopped by Close, or the queue is Reset meanwhile
+		return
+	}
+	es.pending = append(es.pending, items...)
+	es.emitUnprotected()
+}
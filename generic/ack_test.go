@@ -0,0 +1,31 @@
+package generic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckEventQueue(t *testing.T) {
+	ch := make(chan Delivery[testEvent], 10)
+	queue := NewAckEventQueue[testEvent](1, ch, sequenceComparator, 50*time.Millisecond)
+	queue.Push(testEvent{sequence: 1})
+	queue.Push(testEvent{sequence: 2})
+
+	first, second := <-ch, <-ch
+	require.Equal(t, testEvent{sequence: 1}, first.Event)
+	require.Equal(t, testEvent{sequence: 2}, second.Event)
+	require.NotEqual(t, first.ID, second.ID)
+	require.True(t, queue.Ack(second.ID))
+	require.False(t, queue.Ack(second.ID))
+
+	// the first event is not acknowledged, so it comes again
+	redelivered := <-ch
+	require.Equal(t, testEvent{sequence: 1}, redelivered.Event)
+	require.False(t, queue.Ack(first.ID))
+	require.True(t, queue.Ack(redelivered.ID))
+
+	queue.Close()
+	require.False(t, NewEventQueue[testEvent](1, nil, sequenceComparator).Ack(1))
+}
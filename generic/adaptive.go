@@ -0,0 +1,88 @@
+package generic
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// fastWeight and slowWeight are the weights of the latest inter-arrival time
+	// in the short-term and the long-term averages of WithAdaptiveThreshold
+	fastWeight = 0.25
+	slowWeight = 0.01
+)
+
+// adaptive tunes emitThreshold to the arrival rate, see WithAdaptiveThreshold.
+// It keeps two moving averages of the time between arrivals: when the short-term one
+// gets below the long-term one, events arrive in a burst and the threshold grows
+// proportionally, when it gets above, the stream calms down and the threshold shrinks
+type adaptive struct {
+	min, max int
+	// base is the threshold for the steady rate, the one the queue is created with
+	base int
+
+	lastArrival time.Time
+	// fast and slow are the averages in nanoseconds, they are zero until
+	// the second arrival
+	fast, slow float64
+}
+
+// arrive takes the arrival of an event into account and returns the effective threshold
+func (a *adaptive) arrive(now time.Time) int {
+	if a.lastArrival.IsZero() {
+		a.lastArrival = now
+		return a.threshold()
+	}
+	interval := float64(now.Sub(a.lastArrival))
+	a.lastArrival = now
+	if a.slow == 0 {
+		a.fast, a.slow = interval, interval
+	} else {
+		a.fast += fastWeight * (interval - a.fast)
+		a.slow += slowWeight * (interval - a.slow)
+	}
+	return a.threshold()
+}
+
+func (a *adaptive) threshold() int {
+	threshold := float64(a.base)
+	if a.slow > 0 {
+		if a.fast <= 0 {
+			return a.max
+		}
+		threshold *= a.slow / a.fast
+	}
+	return int(math.Max(float64(a.min), math.Min(float64(a.max), math.Round(threshold))))
+}
+
+// adaptUnprotected updates emitThreshold on the arrival of an event
+func (es *EventQueue[T]) adaptUnprotected() {
+	if es.adaptive == nil {
+		return
+	}
+	es.setThresholdUnprotected(es.adaptive.arrive(time.Now()))
+}
+
+// setBaseThresholdUnprotected sets emitThreshold as given by the client,
+// WithAdaptiveThreshold tunes it to the arrival rate from then on
+func (es *EventQueue[T]) setBaseThresholdUnprotected(emitThreshold int) {
+	if es.adaptive != nil {
+		es.adaptive.base = emitThreshold
+		emitThreshold = es.adaptive.threshold()
+	}
+	es.setThresholdUnprotected(emitThreshold)
+}
+
+// baseThresholdUnprotected returns emitThreshold as given by the client
+func (es *EventQueue[T]) baseThresholdUnprotected() int {
+	if es.adaptive != nil {
+		return es.adaptive.base
+	}
+	return es.emitThreshold
+}
+
+// setThresholdUnprotected changes the effective emitThreshold, see Stats
+func (es *EventQueue[T]) setThresholdUnprotected(emitThreshold int) {
+	es.emitThreshold = emitThreshold
+	es.stats.threshold.Store(int64(emitThreshold))
+}
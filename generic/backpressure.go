@@ -0,0 +1,66 @@
+package generic
+
+// backpressure tracks whether the queue is above its soft limit, see WithBackpressure.
+// The signals are queued under EventQueue lock and delivered in order by a dedicated
+// goroutine, so the callbacks never run with the lock held
+type backpressure struct {
+	softLimit, release int
+	onHigh, onLow      func(int)
+
+	above   bool
+	signals []pressureSignal
+	wake    chan struct{}
+}
+
+type pressureSignal struct {
+	high bool
+	len  int
+}
+
+// lenChangedUnprotected must be called whenever the number of buffered events changes
+func (es *EventQueue[T]) lenChangedUnprotected() {
+	n := es.bufferedUnprotected()
+	es.stats.setLen(n)
+	if es.coalesced > n {
+		// the due events are removed, e.g. by Clear or Drain, see WithCoalesceWindow
+		es.coalesced = n
+	}
+
+	bp := es.pressure
+	if bp == nil {
+		return
+	}
+	switch {
+	case !bp.above && n > bp.softLimit:
+		bp.above = true
+	case bp.above && n <= bp.release:
+		bp.above = false
+	default:
+		return
+	}
+	bp.signals = append(bp.signals, pressureSignal{high: bp.above, len: n})
+	select {
+	case bp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signalPressure is the goroutine that calls the callbacks of WithBackpressure.
+// It runs until Close closes wake
+func (es *EventQueue[T]) signalPressure() {
+	bp := es.pressure
+	for range bp.wake {
+		es.lock.Lock()
+		signals := bp.signals
+		bp.signals = nil
+		es.lock.Unlock()
+
+		for _, signal := range signals {
+			if signal.high && bp.onHigh != nil {
+				bp.onHigh(signal.len)
+			} else if !signal.high && bp.onLow != nil {
+				bp.onLow(signal.len)
+			}
+		}
+	}
+}
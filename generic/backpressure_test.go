@@ -0,0 +1,29 @@
+package generic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackpressure(t *testing.T) {
+	signals := make(chan int, 10)
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithBackpressure[testEvent](4,
+		func(curLen int) { signals <- curLen },
+		func(curLen int) { signals <- -curLen },
+	))
+
+	for sequence := uint64(1); sequence <= 6; sequence++ {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, 5, <-signals)
+
+	queue.PopN(3)
+	require.Len(t, signals, 0, "3 events are still above the release threshold")
+	queue.PopN(1)
+	require.Equal(t, -2, <-signals)
+
+	queue.Close()
+	require.Len(t, signals, 0)
+}
@@ -0,0 +1,52 @@
+package generic
+
+import (
+	"context"
+	"errors"
+)
+
+// The errors of EventQueue are sentinels, match them with errors.Is.
+// Note that a nil output is not an error and there is no error for it: every constructor
+// makes the queue pull-only then, see NewEventQueue
+
+// ErrClosed is returned by PushErr, PushContext and Restore when the queue is closed.
+// Push, TryPush, PushAll, PushDedup and CompareAndPush panic with it instead
+var ErrClosed = errors.New("eventqueue: queue is closed")
+
+// ErrFull is returned by PushErr and PushContext when an event is dropped because the queue
+// is full, see WithMaxSize, WithMaxBytes and WithBlockingBounds
+var ErrFull = errors.New("eventqueue: queue is full")
+
+// ErrLate is returned by PushErr and PushContext when an event is rejected because it sorts
+// before an event emitted already, see WithMonotonicEmission
+var ErrLate = errors.New("eventqueue: event is late")
+
+// ErrCanceled is returned by PushContext, FlushContext and the pushes blocked by WithMaxSize
+// or WithBlockingBounds when ctx is done first. The error wraps the error of ctx as well,
+// so errors.Is matches context.Canceled or context.DeadlineExceeded too
+var ErrCanceled = errors.New("eventqueue: canceled")
+
+// ErrComparatorPanic is reported when the comparator panics, see WithComparatorRecovery
+var ErrComparatorPanic = errors.New("eventqueue: comparator panicked")
+
+// ErrInvalidThreshold is returned when emitThreshold is not positive, see SetEmitThreshold
+var ErrInvalidThreshold = errors.New("eventqueue: emitThreshold must be positive")
+
+// canceledError is ErrCanceled caused by the error of a context
+type canceledError struct {
+	cause error
+}
+
+func (e canceledError) Error() string { return ErrCanceled.Error() + ": " + e.cause.Error() }
+
+func (e canceledError) Unwrap() error { return e.cause }
+
+func (e canceledError) Is(target error) bool { return target == ErrCanceled }
+
+// canceled returns ErrCanceled caused by the error of ctx, or nil if ctx is not done
+func canceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return canceledError{cause: err}
+	}
+	return nil
+}
@@ -0,0 +1,2660 @@
+// Package generic provides the implementation of EventQueue built with
+// type parameters (requires Go 1.19+). Comparators and the output channel work
+// with the actual event type, so there is no need to cast events from interface{}.
+// The API of package eventqueue is a thin wrapper over EventQueue[interface{}]
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventQueue allows to process out-of-order incoming events of type T in
+// ordered way. Basically this is a wrapper around a buffer which aggregates events
+// and sorts the events as they come. Once buffer size hits treshold, EventQueue
+// starts emitting the events through output channel.
+// See NewEventQueue for details about instantiating EventQueue.
+//
+// Algorithm complexity of adding the event is O(logN).
+// Algorithm complexity of popping the event out is also O(logN)
+//
+// Concurrency:
+// All methods are safe for concurrent use. The internal lock protects the buffer only,
+// it is never held while an event is being sent to the output channel, so a slow
+// consumer does not prevent other goroutines from pushing events.
+// Events are sent by one goroutine at a time and always in the order they were
+// popped out of the buffer. If a goroutine is already sending when another Push
+// triggers emission, the new event is handed over to the sending goroutine and
+// Push returns without waiting for it to be delivered.
+type EventQueue[T any] struct {
+	emitThreshold int
+	lowWatermark  int
+	output        sink[T]
+	queue         eventPriorityQueue[T]
+	// base is output before wrapping, see wrapOutputUnprotected and AddOutput
+	base sink[T]
+
+	// pending keeps events that are already popped out of the queue but
+	// not sent to output yet, in emission order.
+	pending []T
+	// emitting is set while some goroutine is sending pending events,
+	// idle is signaled once it is done.
+	emitting bool
+	idle     sync.Cond
+
+	// closed is set by Close, drained is closed once Close is done,
+	// ownsOutput tells whether Close has to close output,
+	// pull tells that there is no output at all, see NewEventQueue,
+	// batchOutput tells that output takes the events as slices, see NewBatchEventQueue
+	pull        bool
+	batchOutput bool
+	// paused suppresses automatic emission, see Pause
+	paused bool
+	// manual suppresses automatic emission for good, see WithManualEmit
+	manual     bool
+	closed     bool
+	drained    chan struct{}
+	ownsOutput bool
+	// router picks the channel for each emitted event, see WithRouter
+	router func(T) chan<- T
+	// sentinel is sent by Close after the events if hasSentinel is set, see WithCloseSentinel
+	sentinel    T
+	hasSentinel bool
+
+	initialCapacity int
+	// reuse keeps the capacity of the buffer on Clear, reusePending reuses the slice
+	// of pending events once they are sent, see WithBufferReuse
+	reuse        bool
+	reusePending bool
+
+	// sequence enables gating emission on contiguity, see WithSequence
+	sequence     SequenceFunc[T]
+	nextSequence uint64
+
+	// adaptive tunes emitThreshold to the arrival rate, see WithAdaptiveThreshold
+	adaptive *adaptive
+
+	// window makes the queue emit whole windows of emitThreshold events, see WithWindowMode
+	window bool
+	// urgent is the number of events pushed since the last collection that match
+	// immediate, each of them emits an event regardless of emitThreshold, see WithImmediate
+	immediate func(T) bool
+	urgent    int
+
+	// flushComparator sorts the events flushed at once, flushSorted tells that
+	// the pending events are sorted by it already, see WithFlushComparator
+	flushComparator Comparator[T]
+	flushSorted     bool
+
+	// reverse flips the comparator so the largest events are emitted first
+	reverse bool
+	// recoverComparator recovers panics of the comparator and reports them
+	// to onComparatorPanic, see WithComparatorRecovery
+	recoverComparator bool
+	onComparatorPanic func(error)
+
+	// wake signals the emitter goroutine of WithBackgroundEmitter that
+	// there are pending events, it is nil without the option
+	wake chan struct{}
+
+	// pressure signals the producers when the queue grows too long, see WithBackpressure
+	pressure *backpressure
+	// watermarks tells the consumer up to which sequence the events are delivered,
+	// see WithWatermark
+	watermarks *watermarks[T]
+
+	// reorder enables the reordering metrics of Stats, lastEmitted is the last
+	// event popped for emission, see WithReorderStats
+	reorder bool
+	// monotonic rejects events that sort before lastEmitted, the rejected events
+	// are queued for onLate until the lock is released, see WithMonotonicEmission
+	monotonic   bool
+	onLate      func(T)
+	lates       []T
+	lastEmitted T
+	emittedAny  bool
+
+	// errorPolicy and onError handle the errors of NewEventQueueErrFunc sink,
+	// failure holds the error that stopped it, see WithErrorPolicy
+	errorPolicy ErrorPolicy
+	onError     func(T, error)
+	failure     *atomic.Pointer[sinkFailure]
+	// acks keeps the events of NewAckEventQueue in flight until they are acknowledged
+	acks *acks[T]
+
+	// duplicateSequence detects events with the sequence number of a buffered event,
+	// sequences counts the buffered events by sequence number. The duplicates are
+	// queued for onDuplicate until the lock is released, see WithDuplicateSequence
+	duplicateSequence SequenceFunc[T]
+	sequences         map[uint64]int
+	onDuplicate       func(T)
+	dropDuplicates    bool
+	duplicates        []T
+
+	// shards buffer pushed events, staged is the number of events they hold,
+	// see WithShards
+	shards    []shard[T]
+	nextShard atomic.Uint64
+	staged    atomic.Int64
+
+	// options are kept to create clones, see Clone
+	options []Option[T]
+
+	// sendTimeout bounds the time a single send may take, see WithSendTimeout
+	sendTimeout  time.Duration
+	dropTimedOut bool
+	// timedOut counts the events given up during the current send. A sink returns
+	// the number of events delivered, so these are consumed on top of them
+	timedOut int
+
+	// emitRate limits emission to the number of events per second, see WithEmitRate
+	emitRate  float64
+	emitBurst int
+	limiter   *limiter
+	// ready lets events through one per signal, unpaced is closed by Close to drain
+	// the queue regardless, see WithPacingChannel
+	ready   <-chan struct{}
+	unpaced chan struct{}
+
+	// onPush and onEmit are observability hooks, see WithOnPush and WithOnEmit.
+	// onDrop is called for drops, which are queued until the lock is released
+	onPush func(T)
+	onEmit func(T)
+	// deadline tells when an event expires, the expired events are queued for
+	// onExpired until the lock is released, see WithExpiry
+	deadline  func(T) time.Time
+	onExpired func(T)
+	expired   []T
+	// onEmitBatch is called once per batch of events sent at once, see WithOnEmitBatch
+	onEmitBatch func([]T)
+	onDrop      func(T, DropReason)
+	drops       []droppedEvent[T]
+
+	// spill keeps the largest events on disk, see WithDiskSpill
+	spill *spill[T]
+
+	// sizer estimates the size of an event in bytes, sizeBytes is the total size
+	// of the buffered events, see WithSizer
+	sizer     func(T) int
+	sizeBytes int
+
+	// weigher tells the weight of an event, weight is the total weight of the buffered
+	// events that emitThreshold applies to instead of their count, see WithWeight
+	weigher func(T) int
+	weight  int
+
+	// maxSize and maxBytes bound the queue, overflow tells what to do when it is full
+	maxSize  int
+	maxBytes int
+	overflow OverflowPolicy
+	// pushes block once blockHigh events are undelivered until there are blockLow
+	// of them or less, see WithBlockingBounds
+	blockHigh, blockLow int
+	blocked             bool
+	// sending is the number of events being sent at the moment
+	sending int
+
+	// dedup returns keys of the events, keys holds the keys of buffered events
+	dedup func(T) string
+	keys  map[string]struct{}
+	// seenKey returns keys of the events, seen holds the keys pushed within dedupTTL
+	// in push order, so the expired ones are evicted from the front, see WithDedupTTL
+	seenKey  func(T) string
+	dedupTTL time.Duration
+	seen     map[string]struct{}
+	seenLog  []seenEntry
+
+	// idleTimer flushes the queue once nothing is emitted for flushInterval
+	flushInterval time.Duration
+	idleTimer     *time.Timer
+	// heartbeatTimer sends a beat made by makeBeat once nothing is emitted
+	// for heartbeatInterval, see WithHeartbeat
+	heartbeatInterval time.Duration
+	heartbeatTimer    *time.Timer
+	makeBeat          func() T
+
+	// coalesceTimer sends the events due for emission at the end of the current
+	// coalesceWindow, coalescing is set while it is armed, see WithCoalesceWindow.
+	// The due events stay in the heap meanwhile, coalesced is the number of them:
+	// the smallest coalesced events are sent, it never exceeds the queue length
+	coalesceWindow time.Duration
+	coalesceTimer  *time.Timer
+	coalescing     bool
+	coalesced      int
+
+	// holdTimer emits the smallest event once it has been held for releaseAfter,
+	// events do not reach emitThreshold emission before holdFloor,
+	// see WithMinHold and WithHoldBounds
+	releaseAfter time.Duration
+	holdFloor    time.Duration
+	timestamp    func(T) time.Time
+	holdTimer    *time.Timer
+
+	stats stats
+	// lastEmitAt is the time events were delivered to output last time, see Status
+	lastEmitAt time.Time
+
+	// lock is shared by the lifetimes of the queue, see Reset
+	lock *sync.Mutex
+}
+
+// Comparator is an entity that helps to sort incoming events of type T
+type Comparator[T any] interface {
+	Less(a, b T) bool
+}
+
+// ComparatorFunc implements Comparator interface.
+// Useful for providing comparators as simple functions
+type ComparatorFunc[T any] func(a, b T) bool
+
+func (f ComparatorFunc[T]) Less(a, b T) bool { return f(a, b) }
+
+// CompositeComparator sorts events by the first comparator, each next one only breaks
+// the ties of the ones before it, e.g. sorting by timestamp and then by source.
+// Events equal for all the comparators are equal
+func CompositeComparator[T any](comparators ...Comparator[T]) Comparator[T] {
+	return ComparatorFunc[T](func(a, b T) bool {
+		for _, comparator := range comparators {
+			if comparator.Less(a, b) {
+				return true
+			}
+			if comparator.Less(b, a) {
+				return false
+			}
+		}
+		return false
+	})
+}
+
+// reverseComparator sorts events in descending order of the wrapped comparator
+type reverseComparator[T any] struct {
+	Comparator[T]
+}
+
+func (c reverseComparator[T]) Less(a, b T) bool { return c.Comparator.Less(b, a) }
+
+// recoveringComparator recovers panics of the wrapped comparator, the panicking
+// comparison reports false, so the events are treated as equal
+type recoveringComparator[T any] struct {
+	Comparator[T]
+	onPanic func(error)
+}
+
+func (c recoveringComparator[T]) Less(a, b T) (less bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			less = false
+			if c.onPanic != nil {
+				c.onPanic(fmt.Errorf("%w: %v", ErrComparatorPanic, r))
+			}
+		}
+	}()
+	return c.Comparator.Less(a, b)
+}
+
+// wrapComparator applies WithReverse and WithComparatorRecovery to the client's comparator
+func (es *EventQueue[T]) wrapComparator(comparator Comparator[T]) Comparator[T] {
+	if es.reverse {
+		comparator = reverseComparator[T]{comparator}
+	}
+	if es.recoverComparator {
+		comparator = recoveringComparator[T]{Comparator: comparator, onPanic: es.onComparatorPanic}
+	}
+	return comparator
+}
+
+// unwrapComparator returns the client's comparator undoing wrapComparator
+func (es *EventQueue[T]) unwrapComparator() Comparator[T] {
+	comparator := es.queue.comparator
+	if recovering, ok := comparator.(recoveringComparator[T]); ok && es.recoverComparator {
+		comparator = recovering.Comparator
+	}
+	if reversed, ok := comparator.(reverseComparator[T]); ok && es.reverse {
+		comparator = reversed.Comparator
+	}
+	return comparator
+}
+
+// SequenceFunc returns the monotonic sequence number of an event, see WithSequence
+type SequenceFunc[T any] func(T) uint64
+
+// NewEventQueue creates EventQueue
+// - emitThreshold - this value sets number of events to accumulate before they start
+// emitting through output channel
+// - outputChannel - is an INPUT channel which will receive emitted events. The channel is injected
+// by a client of EventQueue and controlled by it. A nil channel makes the queue pull-only:
+// nothing is emitted, not even by Flush or Close, and the events are taken with Next, PopN
+// or Drain. Without it a send to a nil channel would block forever. The other constructors
+// treat a nil output the same way, be it a channel, a function, a Sink or an io.Writer
+// - comparator helps to sort your events
+// - options tune optional behavior of the queue, see With* functions
+func NewEventQueue[T any](emitThreshold int, outputChannel chan<- T, comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	return newEventQueue[T](emitThreshold, channelSink[T](outputChannel), comparator, options)
+}
+
+// NewBatchEventQueue creates EventQueue that sends emitted events to outputChannel
+// as sorted slices instead of one by one: all the events emitted at once, e.g. drained
+// on a threshold trigger (see WithLowWatermark) or by Flush, go in a single send.
+// Each slice is sorted in ascending order, even if an event pushed while the slice
+// was waiting for the consumer sorts before the events taken earlier (unless WithAging
+// is used, then the slice is in emission order).
+// The slices are owned by the receiver. See NewEventQueue for the parameters
+func NewBatchEventQueue[T any](emitThreshold int, outputChannel chan<- []T, comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	return newEventQueue[T](emitThreshold, batchSink[T](outputChannel), comparator, options)
+}
+
+// NewEventQueueFunc creates EventQueue that emits events by calling sink instead
+// of sending them to a channel. The sink is called synchronously, one event at a time and
+// in the same order as the events would be sent to a channel, by the goroutine emitting
+// the events with the queue lock released. A slow sink slows down the queue, and the sink
+// must not call back into the queue. WithOwnedOutput has no effect
+func NewEventQueueFunc[T any](emitThreshold int, sink func(T), comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	if sink == nil {
+		return newEventQueue[T](emitThreshold, channelSink[T](nil), comparator, options)
+	}
+	return newEventQueue[T](emitThreshold, funcSink[T](sink), comparator, options)
+}
+
+// NewEventQueueErrFunc creates EventQueue that emits events by calling sink like
+// NewEventQueueFunc does, but the sink may fail. What happens to the event that the sink
+// fails to take is set by WithErrorPolicy, by default it is retried. See Err
+func NewEventQueueErrFunc[T any](emitThreshold int, sink func(T) error, comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	if sink == nil {
+		return newEventQueue[T](emitThreshold, channelSink[T](nil), comparator, options)
+	}
+	s := &errFuncSink[T]{fn: sink}
+	es := newEventQueue[T](emitThreshold, s, comparator, options)
+	s.policy, s.onError = es.errorPolicy, es.onError
+	es.failure = &s.failure
+	return es
+}
+
+// NewEventQueueWriter creates EventQueue that writes the emitted events to w in order,
+// each one encoded by encode, e.g. as a line of JSON. A failed encoding or write is handled
+// like a failure of the sink of NewEventQueueErrFunc, see WithErrorPolicy: when a retried
+// event was written partially, only the rest of it is written again, so w gets every
+// event whole. If an event pushed meanwhile goes before the retry, the rest is written
+// ahead of it. The events are told apart by their encodings, so equal encodings must
+// mean equal events. A write that takes no bytes without an error fails with io.ErrShortWrite
+func NewEventQueueWriter[T any](emitThreshold int, w io.Writer, encode func(T) ([]byte, error), comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	if w == nil {
+		return newEventQueue[T](emitThreshold, channelSink[T](nil), comparator, options)
+	}
+	writer := &eventWriter[T]{w: w, encode: encode}
+	es := NewEventQueueErrFunc[T](emitThreshold, writer.write, comparator, options...)
+	writer.dropFailed = es.errorPolicy == DropOnError
+	return es
+}
+
+// NewEventQueueSink creates EventQueue that emits events into sink, e.g. a lock-free
+// ring buffer, instead of a channel, which NewEventQueue uses by default. The events
+// are put in order by the goroutine emitting them with the queue lock released.
+// When the sink is full, the events that do not fit stay in the queue and are put
+// by the next emission, e.g. on the next Push or Flush. WithOwnedOutput has no effect
+func NewEventQueueSink[T any](emitThreshold int, sink Sink[T], comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	if sink == nil {
+		return newEventQueue[T](emitThreshold, channelSink[T](nil), comparator, options)
+	}
+	return newEventQueue[T](emitThreshold, trySink[T]{sink}, comparator, options)
+}
+
+// NewEventQueueFromSlice creates EventQueue like NewEventQueue does and buffers the items
+// at once, which heapifies them in O(n) instead of pushing them one by one in O(n log n).
+// The items are copied. Nothing is emitted until the next Push or Flush,
+// even if there are emitThreshold items or more
+func NewEventQueueFromSlice[T any](items []T, emitThreshold int, outputChannel chan<- T, comparator Comparator[T], options ...Option[T]) *EventQueue[T] {
+	es := newEventQueue[T](emitThreshold, channelSink[T](outputChannel), comparator, options)
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	es.loadUnprotected(append([]T(nil), items...))
+	es.stats.pushed.Add(uint64(len(items)))
+	return es
+}
+
+// NewReorderBuffer creates EventQueue that works as a jitter buffer for timestamped
+// events: they are sorted by timestamp and each one is emitted once hold has passed
+// since its timestamp, regardless of how many events are buffered (see WithMinHold).
+// The emission is driven only by a timer set to the oldest timestamp plus hold, so an event
+// with a timestamp ahead of the local clock waits until hold has passed since it.
+// options are applied after these ones
+func NewReorderBuffer[T any](timestamp func(T) time.Time, hold time.Duration, outputChannel chan<- T, options ...Option[T]) *EventQueue[T] {
+	comparator := ComparatorFunc[T](func(a, b T) bool { return timestamp(a).Before(timestamp(b)) })
+	options = append([]Option[T]{WithMinHold[T](hold, timestamp)}, options...)
+	return newEventQueue[T](math.MaxInt, channelSink[T](outputChannel), comparator, options)
+}
+
+func newEventQueue[T any](emitThreshold int, output sink[T], comparator Comparator[T], options []Option[T]) *EventQueue[T] {
+	es := &EventQueue[T]{}
+	es.init(emitThreshold, output, comparator, options, &sync.Mutex{}, nil)
+	return es
+}
+
+// init sets the queue up from scratch with the given lock, which is never replaced
+// since other goroutines may be waiting for it. The events are buffered in buffer
+// if it has the initial capacity at least
+func (es *EventQueue[T]) init(emitThreshold int, output sink[T], comparator Comparator[T], options []Option[T], lock *sync.Mutex, buffer []T) {
+	*es = EventQueue[T]{
+		emitThreshold:   emitThreshold,
+		lowWatermark:    -1,
+		base:            output,
+		initialCapacity: defaultCapacity,
+		drained:         make(chan struct{}),
+		options:         options,
+		lock:            lock,
+
+		queue: eventPriorityQueue[T]{
+			comparator: comparator,
+		},
+	}
+	es.idle.L = es.lock
+	for _, option := range options {
+		option(es)
+	}
+	es.setBaseThresholdUnprotected(emitThreshold)
+	switch ch := output.(type) {
+	case channelSink[T]:
+		es.pull = ch == nil
+	case batchSink[T]:
+		es.pull = ch == nil
+		es.batchOutput = true
+	}
+	if es.emitRate > 0 {
+		es.limiter = newLimiter(es.emitRate, es.emitBurst)
+	}
+	if es.ready != nil {
+		es.unpaced = make(chan struct{})
+	}
+	es.wrapOutputUnprotected()
+	if es.wake != nil {
+		go es.emitInBackground()
+	}
+	if es.pressure != nil {
+		go es.signalPressure()
+	}
+	if es.watermarks != nil {
+		go es.signalWatermarks()
+	}
+	if !es.shardable() {
+		es.shards = nil
+	}
+	if es.sizer == nil {
+		// the size of events is unknown
+		es.maxBytes = 0
+	}
+	if es.reuse {
+		// batchSink hands the slice over to the consumer, so it can't be reused
+		_, batch := output.(batchSink[T])
+		es.reusePending = !batch
+	}
+	es.queue.comparator = es.wrapComparator(comparator)
+	es.queue.reuse = es.reuse
+	if cap(buffer) < es.initialCapacity {
+		buffer = make([]T, 0, es.initialCapacity)
+	}
+	es.queue.data = buffer[:0]
+	if es.flushInterval > 0 {
+		es.idleTimer = time.AfterFunc(es.flushInterval, es.flushIdle)
+	}
+	if es.heartbeatInterval > 0 {
+		es.heartbeatTimer = time.AfterFunc(es.heartbeatInterval, es.heartbeat)
+	}
+}
+
+// wrapOutputUnprotected sets output to base wrapped into the sinks of WithSendTimeout,
+// WithRouter, WithEmitRate and WithPacingChannel. With several outputs (see AddOutput)
+// each channel gets its own send timeout, the rest wrap all the outputs at once
+func (es *EventQueue[T]) wrapOutputUnprotected() {
+	es.output = es.base
+	if es.sendTimeout > 0 {
+		var drop func([]T)
+		if es.dropTimedOut {
+			drop = es.dropTimedOutEvents
+		}
+		if sinks, ok := es.base.(fanoutSink[T]); ok {
+			outputs := make(fanoutSink[T], len(sinks))
+			for i, output := range sinks {
+				if i > 0 {
+					// the event is delivered to the first output or dropped already,
+					// another output that does not take it in time just skips it
+					drop = func([]T) {}
+				}
+				outputs[i] = output
+				if timeoutOutput, ok := newTimeoutSink[T](output, es.sendTimeout, drop); ok {
+					outputs[i] = timeoutOutput
+				}
+			}
+			es.output = outputs
+		} else if timeoutOutput, ok := newTimeoutSink[T](es.base, es.sendTimeout, drop); ok {
+			es.output = timeoutOutput
+		}
+	}
+	if es.router != nil {
+		es.output = routerSink[T]{sink: es.output, route: es.router}
+	}
+	if es.limiter != nil {
+		es.output = rateSink[T]{sink: es.output, limiter: es.limiter, batch: es.batchOutput}
+	}
+	if es.ready != nil {
+		es.output = pacedSink[T]{sink: es.output, ready: es.ready, unpaced: es.unpaced, batch: es.batchOutput}
+	}
+}
+
+// Push adds an event to the queue in an ordered matter.
+// If another goroutine is sending events to output channel at the moment,
+// the emitted event is left to that goroutine and Push returns immediately.
+// Push panics if the queue is closed, see PushErr for a non-panicking variant
+func (es *EventQueue[T]) Push(item T) {
+	if err := es.PushErr(item); errors.Is(err, ErrClosed) {
+		panic(err)
+	}
+}
+
+// PushErr adds an event to the queue like Push does, but returns ErrClosed
+// instead of panicking if the queue is closed. With WithMaxSize it returns ErrFull
+// if an event is dropped because the queue is full
+func (es *EventQueue[T]) PushErr(item T) error {
+	_, err := es.push(item)
+	return err
+}
+
+// PushDedup adds an event to the queue like Push does and reports whether the event
+// is accepted. With WithDedup an event is rejected if an event with the same key
+// is buffered already. PushDedup panics if the queue is closed
+func (es *EventQueue[T]) PushDedup(item T) bool {
+	accepted, err := es.push(item)
+	if errors.Is(err, ErrClosed) {
+		panic(err)
+	}
+	return accepted
+}
+
+func (es *EventQueue[T]) push(item T) (accepted bool, err error) {
+	if es.shards != nil {
+		return es.stage(item)
+	}
+	es.lockMerged()
+	defer func() {
+		if accepted {
+			es.notifyPush(item)
+		}
+	}()
+	defer es.unlock()
+
+	return es.admitUnprotected(item)
+}
+
+// admitUnprotected checks an event against the options of the queue and pushes it
+// like push does, but with es.lock held already
+func (es *EventQueue[T]) admitUnprotected(item T) (accepted bool, err error) {
+	if es.closed {
+		return false, ErrClosed
+	}
+	if es.duplicateUnprotected(item) {
+		return false, nil
+	}
+	if es.lateUnprotected(item) {
+		return false, ErrLate
+	}
+	if es.duplicateSequenceUnprotected(item) {
+		return false, nil
+	}
+	accepted, err = es.makeRoomUnprotected(context.Background(), true, item)
+	if !accepted {
+		return false, err
+	}
+	es.observeUnprotected(item)
+	es.pushUnprotected(item)
+	es.stats.pushed.Add(1)
+	es.collectUnprotected(1)
+	es.emitUnprotected()
+	return true, err
+}
+
+// PushAll adds several events to the queue at once, taking the lock once.
+// It emits as many events as the same number of Push calls would, but picks them
+// among all the events including the new ones, so the emitted events are the smallest.
+// A large batch is heapified at once instead of pushing the events one by one.
+// With WithMaxSize, WithMaxBytes, WithBlockingBounds or WithDuplicateSequence the events
+// are pushed one by one like Push does. PushAll panics if the queue is closed
+func (es *EventQueue[T]) PushAll(items []T) {
+	if es.maxSize > 0 || es.maxBytes > 0 || es.blockHigh > 0 || es.duplicateSequence != nil {
+		for _, item := range items {
+			es.Push(item)
+		}
+		return
+	}
+
+	var accepted []T
+	es.lockMerged()
+	defer func() {
+		for _, item := range accepted {
+			es.notifyPush(item)
+		}
+	}()
+	defer es.unlock()
+
+	if es.closed {
+		panic(ErrClosed)
+	}
+	accepted = items
+	if es.dedup != nil || es.seenKey != nil || es.monotonic {
+		accepted = make([]T, 0, len(items))
+		for _, item := range items {
+			if es.duplicateUnprotected(item) || es.lateUnprotected(item) {
+				continue
+			}
+			if es.dedup != nil {
+				es.keys[es.dedup(item)] = struct{}{}
+			}
+			es.seeUnprotected(item)
+			accepted = append(accepted, item)
+		}
+	}
+	for _, item := range accepted {
+		es.observeUnprotected(item)
+	}
+	if len(accepted) >= es.queue.Len() {
+		es.loadUnprotected(accepted)
+		for _, item := range accepted {
+			es.urgeUnprotected(item)
+		}
+	} else {
+		for _, item := range accepted {
+			es.pushUnprotected(item)
+		}
+	}
+	es.stats.pushed.Add(uint64(len(accepted)))
+	es.collectUnprotected(len(accepted))
+	es.emitUnprotected()
+}
+
+// PushContext adds an event to the queue like Push does, but gives up waiting for
+// the output channel once ctx is done. If nothing is emitted by then, ErrCanceled is returned
+// and the queue is left as if PushContext was never called: the item is not added and the
+// events that were about to be emitted stay in the queue. If ctx is done in the middle of
+// emitting several events (see WithLowWatermark), the item is added, the events not sent
+// yet stay in the queue and nil is returned, so an error means the item is not added.
+// The only exception is ErrFull under DropOldest policy, see WithMaxSize.
+// Unlike Push, PushContext waits for its turn if another goroutine is sending events
+// at the moment. ErrClosed is returned if the queue is closed, including the case when
+// Close takes over while PushContext is waiting
+func (es *EventQueue[T]) PushContext(ctx context.Context, item T) error {
+	pushed := false
+	es.lockMerged()
+	defer func() {
+		if pushed {
+			es.notifyPush(item)
+		}
+	}()
+	defer es.unlock()
+
+	if es.closed {
+		return ErrClosed
+	}
+	if err := canceled(ctx); err != nil {
+		return err
+	}
+	if es.duplicateUnprotected(item) {
+		return nil
+	}
+	if es.lateUnprotected(item) {
+		return ErrLate
+	}
+	if es.duplicateSequenceUnprotected(item) {
+		return nil
+	}
+
+	stop := es.wakeUpOnDone(ctx.Done())
+	defer stop()
+	accepted, err := es.makeRoomUnprotected(ctx, true, item)
+	if !accepted {
+		return err
+	}
+	es.observeUnprotected(item)
+	incoming := es.weightUnprotected(item)
+	due := es.dueUnprotected(es.bufferedUnprotected()+1, 1, es.weight+incoming)
+	if due == 0 {
+		es.pushUnprotected(item)
+		es.stats.pushed.Add(1)
+		pushed = true
+		return err
+	}
+
+	if !es.beginEmit(true, ctx.Done()) {
+		return canceled(ctx)
+	}
+	if es.closed {
+		// Close took over while we were waiting for our turn
+		es.endEmitUnprotected()
+		return ErrClosed
+	}
+
+	// the emitted events are the smallest ones among the queued events and the item.
+	// The item is added to the queue only once something is delivered,
+	// so there is nothing to roll back if ctx is done earlier
+	nextSequence := es.nextSequence
+	itemAt := -1
+	for n := due; n > 0 && es.heavyUnprotected(incoming); n-- {
+		if itemAt < 0 && es.queue.precedes(item) {
+			if !es.readyUnprotected(item) || !es.settledUnprotected(item) {
+				break
+			}
+			itemAt = len(es.pending)
+			es.pending = append(es.pending, item)
+			es.advanceUnprotected(item)
+			incoming = 0
+		} else {
+			if !es.readyUnprotected(es.queue.data[0]) || !es.settledUnprotected(es.queue.data[0]) {
+				break
+			}
+			es.takeUnprotected()
+		}
+	}
+	own := len(es.pending)
+	if own == 0 {
+		// held back by WithSequence
+		es.pushUnprotected(item)
+		es.stats.pushed.Add(1)
+		pushed = true
+		es.endEmitUnprotected()
+		return err
+	}
+
+	// without ctx being done only a failing sink delivers nothing,
+	// then the item stays in the queue to be retried like the other events
+	if es.sendUnprotected(ctx.Done()) == 0 && ctx.Err() != nil {
+		if itemAt >= 0 {
+			es.pending = append(es.pending[:itemAt], es.pending[itemAt+1:]...)
+		}
+		es.rebufferUnprotected()
+		es.nextSequence = nextSequence
+		return canceled(ctx)
+	}
+
+	if itemAt < 0 {
+		es.pushUnprotected(item)
+	}
+	es.stats.pushed.Add(1)
+	pushed = true
+	es.rebufferUnprotected()
+	return err
+}
+
+// TryPush adds an event to the queue like Push does, but never blocks on output channel.
+// If the events due for emission can not be emitted right away, because output channel is full
+// or another goroutine is sending events at the moment, TryPush returns false and the events stay
+// buffered, so they are emitted later by Push or Flush.
+// Note that if the consumer is slow the queue may grow beyond emitThreshold.
+// If the queue is full under Block policy (see WithMaxSize), TryPush does not wait:
+// it returns false and the event is dropped
+func (es *EventQueue[T]) TryPush(item T) bool {
+	pushed := false
+	es.lockMerged()
+	defer func() {
+		if pushed {
+			es.notifyPush(item)
+		}
+	}()
+	defer es.unlock()
+
+	if es.closed {
+		panic(ErrClosed)
+	}
+	if es.duplicateUnprotected(item) {
+		return true
+	}
+	if es.lateUnprotected(item) {
+		return false
+	}
+	if es.duplicateSequenceUnprotected(item) {
+		return true
+	}
+	if accepted, _ := es.makeRoomUnprotected(nil, false, item); !accepted {
+		return false
+	}
+	es.observeUnprotected(item)
+	es.pushUnprotected(item)
+	es.stats.pushed.Add(1)
+	pushed = true
+	if es.dueUnprotected(es.bufferedUnprotected(), 1, es.weight) == 0 {
+		return true
+	}
+	if !es.beginEmit(false, nil) {
+		return false
+	}
+
+	es.collectUnprotected(1)
+	own := len(es.pending)
+	emitted := es.sendUnprotected(closedChan) >= own
+	es.rebufferUnprotected()
+
+	return emitted
+}
+
+// Flush pushes the rest of the aggregated events to output channel in ascending order.
+// At the end the queue is empty. Flush waits for its turn if another goroutine
+// is sending events at the moment.
+// The events are popped out at once under the lock and sent with the lock released,
+// so producers keep pushing while Flush waits for the consumer. The events pushed
+// meanwhile and due for emission are sent by Flush too, after the flushed ones
+func (es *EventQueue[T]) Flush() {
+	es.lockMerged()
+	defer es.unlock()
+
+	es.beginEmit(true, nil)
+	es.stats.flushes.Add(1)
+	es.collectAllUnprotected()
+	es.sendUnprotected(nil)
+}
+
+// EmitNow pops the smallest event and sends it to output regardless of emitThreshold,
+// e.g. to drain the queue at an external pace. Like Flush it waits for its turn if another
+// goroutine is sending events, and the events taken for emission meanwhile are sent too.
+// It returns false if the queue is empty or pull-only
+func (es *EventQueue[T]) EmitNow() bool {
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.pull || es.bufferedUnprotected() == 0 {
+		return false
+	}
+	es.beginEmit(true, nil)
+	if es.bufferedUnprotected() == 0 {
+		// emitted by the goroutine we were waiting for
+		es.endEmitUnprotected()
+		return false
+	}
+	es.takeUnprotected()
+	es.sendUnprotected(nil)
+	return true
+}
+
+// FlushIf emits in sorted order the buffered events for which match returns true,
+// the rest stays in the queue. It returns the number of events sent, which includes
+// the events pushed meanwhile and due for emission, like Flush does.
+// All the events are popped out of the heap and the rest is heapified back,
+// so FlushIf costs O(n log n) however few events match. match is called with
+// the queue lock held, so it must not call the queue or panic. Like Flush, FlushIf
+// does nothing on a pull-only queue and returns 0, the events stay buffered
+func (es *EventQueue[T]) FlushIf(match func(T) bool) int {
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.pull {
+		return 0
+	}
+	es.beginEmit(true, nil)
+	es.stats.flushes.Add(1)
+	var kept []T
+	for es.queue.Len() > 0 {
+		if match(es.queue.data[0]) {
+			es.takeUnprotected()
+		} else {
+			kept = append(kept, es.removeUnprotected(0, false))
+		}
+	}
+	es.loadUnprotected(kept)
+	return es.sendUnprotected(nil)
+}
+
+// FlushContext pushes the rest of the aggregated events to output channel like Flush
+// does, but gives up once ctx is done. In that case the events not sent yet stay
+// in the queue in order and ErrCanceled is returned along with the number of events
+// left in the queue. On success the number of remaining events is 0, unless other
+// goroutines pushed more events meanwhile
+func (es *EventQueue[T]) FlushContext(ctx context.Context) (int, error) {
+	_, remaining, err := es.flushContext(ctx)
+	return remaining, err
+}
+
+// TryFlush pushes the rest of the aggregated events to output channel like Flush does,
+// but gives up after timeout, so it drains the queue in bounded time as far as
+// the consumer keeps up. It returns the number of emitted events and the number
+// of events left in the queue in order
+func (es *EventQueue[T]) TryFlush(timeout time.Duration) (emitted, remaining int) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	emitted, remaining, _ = es.flushContext(ctx)
+	return emitted, remaining
+}
+
+func (es *EventQueue[T]) flushContext(ctx context.Context) (emitted, remaining int, err error) {
+	es.lockMerged()
+	defer es.unlock()
+
+	if err := canceled(ctx); err != nil {
+		return 0, es.bufferedUnprotected(), err
+	}
+	stop := es.wakeUpOnDone(ctx.Done())
+	defer stop()
+	if !es.beginEmit(true, ctx.Done()) {
+		return 0, es.bufferedUnprotected(), canceled(ctx)
+	}
+
+	es.stats.flushes.Add(1)
+	es.collectAllUnprotected()
+	emitted = es.sendUnprotected(ctx.Done())
+	interrupted := len(es.pending) > 0
+	es.rebufferUnprotected()
+	if interrupted {
+		return emitted, es.bufferedUnprotected(), canceled(ctx)
+	}
+	return emitted, es.bufferedUnprotected(), nil
+}
+
+// Close signals that no more events will arrive. It flushes the rest of the
+// aggregated events to output channel in order and closes the channel if the queue
+// owns it (see WithOwnedOutput). Any Push after Close panics, PushErr and PushContext
+// return ErrClosed. A pull-only queue (see NewEventQueue) keeps the events for Drain.
+// Close is idempotent, subsequent calls do nothing, see Wait to wait for the drain
+func (es *EventQueue[T]) Close() {
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.closed {
+		return
+	}
+	es.closed = true
+	// the shards are closed too, so the events pushed later are not lost
+	es.mergeShardsUnprotected()
+	if es.unpaced != nil {
+		// lets through a send waiting for a signal too
+		close(es.unpaced)
+	}
+	// closed even if the drain panics, so that Wait does not hang
+	defer close(es.drained)
+
+	es.beginEmit(true, nil)
+	es.collectAllUnprotected()
+	es.sendUnprotected(nil)
+	if es.hasSentinel && !es.pull {
+		es.beginEmit(true, nil)
+		es.sendAsideUnprotected(es.sentinel, nil)
+		es.endEmitUnprotected()
+	}
+	// stopped after the drain, since each emission restarts the timer
+	if es.idleTimer != nil {
+		es.idleTimer.Stop()
+	}
+	if es.heartbeatTimer != nil {
+		es.heartbeatTimer.Stop()
+	}
+	if es.holdTimer != nil {
+		es.holdTimer.Stop()
+	}
+	if es.coalesceTimer != nil {
+		es.coalesceTimer.Stop()
+	}
+	if es.acks != nil {
+		es.acks.stop()
+	}
+	if es.wake != nil {
+		close(es.wake)
+	}
+	if es.pressure != nil {
+		close(es.pressure.wake)
+	}
+	if es.watermarks != nil {
+		close(es.watermarks.wake)
+		close(es.watermarks.done)
+	}
+
+	if es.ownsOutput && !es.pull {
+		es.output.close()
+	}
+}
+
+// Reset closes the queue, unless it is closed already, and sets it up again in place
+// as if it was created by NewEventQueue with outputChannel, comparator, the current
+// emitThreshold and the options of the queue. Stats, Err and the other state start
+// from scratch, but the buffer keeps its capacity, so queues can be pooled, e.g. with
+// sync.Pool, without reallocating it.
+// Reset must not be called on a queue with buffered events, e.g. a pull-only queue that
+// is not drained, since they are discarded, nor concurrently with other methods
+func (es *EventQueue[T]) Reset(outputChannel chan<- T, comparator Comparator[T]) {
+	es.Close()
+
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	buffer := es.queue.data
+	var zero T
+	for i := range buffer {
+		buffer[i] = zero
+	}
+	es.init(es.baseThresholdUnprotected(), channelSink[T](outputChannel), comparator, es.options, es.lock, buffer)
+}
+
+// Wait blocks until Close has drained the queue, e.g. when Close is called by another
+// goroutine. It may be called by several goroutines at once
+func (es *EventQueue[T]) Wait() {
+	<-es.drained
+}
+
+// Done returns a channel that is closed once Close has drained the queue, see Wait
+func (es *EventQueue[T]) Done() <-chan struct{} {
+	return es.drained
+}
+
+// Len returns current length of the queue. It is safe to call concurrently with
+// pushes and does not wait for the queue lock, since the length is kept in an atomic
+// counter updated every time the buffer changes. Events being sent at the moment
+// are not counted
+func (es *EventQueue[T]) Len() int {
+	return int(es.stats.len.Load() + es.staged.Load())
+}
+
+// Clear drops all the buffered events without emitting them, so unlike Flush
+// it loses data. Output channel is not touched.
+// If the buffer has grown beyond its initial capacity, the memory is released
+func (es *EventQueue[T]) Clear() {
+	es.lockMerged()
+	defer es.unlock()
+
+	es.clearUnprotected()
+}
+
+// SizeBytes returns the estimated size in bytes of the buffered events as reported
+// by the sizer of WithSizer, it returns 0 without the option. Events being sent
+// at the moment are not counted
+func (es *EventQueue[T]) SizeBytes() int {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return es.sizeBytes
+}
+
+// Weight returns the total weight of the buffered events as reported by the weigher
+// of WithWeight, it returns 0 without the option. Events being sent at the moment are not counted
+func (es *EventQueue[T]) Weight() int {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return es.weight
+}
+
+// Peek returns the smallest buffered event without removing it from the queue.
+// The second value is false if the queue is empty
+func (es *EventQueue[T]) Peek() (T, bool) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.queue.age()
+	if es.queue.Len() == 0 {
+		var zero T
+		return zero, false
+	}
+	return es.queue.data[0], true
+}
+
+// WillEmitOnNextPush tells whether the next Push emits an event, i.e. the queue holds
+// emitThreshold-1 events or more, reading the length and the threshold at once.
+// It does not tell whether the event is held back, e.g. by WithSequence or WithHoldBounds.
+// With WithWeight it tells whether the events weigh emitThreshold without the next one.
+// It is false while the queue is paused, pull-only or in manual mode
+func (es *EventQueue[T]) WillEmitOnNextPush() bool {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return es.dueUnprotected(es.bufferedUnprotected()+1, 1, es.weight) > 0
+}
+
+// Bounds returns the smallest and the largest buffered events, e.g. to see the time span
+// of the events in flight. The largest one is a leaf of the heap, so finding it scans
+// half the queue unless the events are pushed in order. The third value is false
+// if the queue is empty
+func (es *EventQueue[T]) Bounds() (min, max T, ok bool) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+	es.queue.age()
+	n := es.queue.Len()
+	if n == 0 {
+		return min, max, false
+	}
+	if es.queue.inOrder {
+		return es.queue.data[0], es.queue.data[n-1], true
+	}
+	largest := n / 2
+	for i := largest + 1; i < n; i++ {
+		if es.queue.Less(largest, i) {
+			largest = i
+		}
+	}
+	return es.queue.data[0], es.queue.data[largest], true
+}
+
+// Pause stops automatic emission: the queue keeps accepting events, but reaching
+// emitThreshold, WithMinHold and WithFlushInterval emit nothing until Resume.
+// Flush, Close and the pull methods like PopN work as usual. Events already taken
+// for emission are still sent. Use WithMaxSize to bound the queue while it is paused
+func (es *EventQueue[T]) Pause() {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.paused = true
+}
+
+// Resume restarts emission stopped by Pause. The events due meanwhile are emitted
+// right away as if they were pushed just now. A queue in manual mode stays in it,
+// see WithManualEmit
+func (es *EventQueue[T]) Resume() {
+	es.lockMerged()
+	defer es.unlock()
+
+	if !es.paused {
+		return
+	}
+	es.paused = false
+	if es.closed {
+		return
+	}
+	if es.timestamp != nil {
+		es.holdUnprotected()
+	}
+	es.collectUnprotected(es.bufferedUnprotected())
+	es.emitUnprotected()
+}
+
+// SetEmitThreshold changes emitThreshold, e.g. to batch more when the load grows.
+// If the queue already holds emitThreshold events or more, they are emitted right away
+// as if they were pushed just now. With WithAdaptiveThreshold it sets the threshold
+// for the steady rate. A threshold that is not positive is rejected with ErrInvalidThreshold
+func (es *EventQueue[T]) SetEmitThreshold(emitThreshold int) error {
+	if emitThreshold <= 0 {
+		return ErrInvalidThreshold
+	}
+
+	es.lockMerged()
+	defer es.unlock()
+
+	es.setBaseThresholdUnprotected(emitThreshold)
+	if es.closed {
+		return nil
+	}
+	es.collectUnprotected(es.bufferedUnprotected())
+	es.emitUnprotected()
+	return nil
+}
+
+// SetComparator replaces the comparator, e.g. to switch from sorting by timestamp
+// to sorting by priority, and rebuilds the heap over the buffered events in O(n).
+// Events being sent at the moment keep going in the order they were popped.
+// With WithReverse the new comparator is reversed too
+func (es *EventQueue[T]) SetComparator(comparator Comparator[T]) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	// the spilled runs are sorted by the old comparator, so they are spilled anew
+	es.unspillUnprotected()
+	es.queue.comparator = es.wrapComparator(comparator)
+	es.queue.init()
+	es.spillUnprotected()
+	if es.timestamp != nil {
+		es.holdUnprotected()
+	}
+}
+
+// Clone creates an independent copy of the queue with the same settings and the same
+// buffered events, e.g. to evaluate what-if scenarios. The copy sends events to
+// outputChannel instead of the output of the queue, outputs added by AddOutput are not
+// copied, and the copy owns outputChannel only if WithOwnedOutput is set. The copy starts
+// with fresh Stats and is not closed even if the queue is. Events being sent
+// at the moment are not copied
+func (es *EventQueue[T]) Clone(outputChannel chan<- T) *EventQueue[T] {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	clone := newEventQueue[T](es.baseThresholdUnprotected(), channelSink[T](outputChannel), es.unwrapComparator(), es.options)
+
+	clone.lock.Lock()
+	defer clone.lock.Unlock()
+	clone.nextSequence = es.nextSequence
+	clone.lastEmitted, clone.emittedAny = es.lastEmitted, es.emittedAny
+	es.unspillUnprotected()
+	clone.loadUnprotected(es.queue.sorted())
+	es.spillUnprotected()
+	return clone
+}
+
+// SplitAt moves the buffered events that do not sort before boundary to a new queue
+// and returns it, e.g. to rebalance buffered work between shards. boundary is compared
+// with the comparator of the queue, an event equal to it moves. The new queue has the
+// same settings like a Clone and emits to outputChannel, it is pull-only if outputChannel
+// is nil (see NewEventQueue). The moved events are not emitted until the next push
+// or flush of the new queue. The heap is partitioned and both parts are heapified
+// back, so SplitAt costs O(n). Events being sent at the moment stay with the queue
+func (es *EventQueue[T]) SplitAt(boundary T, outputChannel chan<- T) *EventQueue[T] {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	split := newEventQueue[T](es.baseThresholdUnprotected(), channelSink[T](outputChannel), es.unwrapComparator(), es.options)
+
+	split.lock.Lock()
+	defer split.lock.Unlock()
+	split.nextSequence = es.nextSequence
+	split.lastEmitted, split.emittedAny = es.lastEmitted, es.emittedAny
+
+	es.unspillUnprotected()
+	es.queue.age()
+	moved := es.queue.partition(func(item T) bool { return !es.queue.comparator.Less(item, boundary) })
+	es.unloadUnprotected(moved)
+	split.loadUnprotected(moved)
+	return split
+}
+
+// merging lets one Merge run at a time, so that the locks of two queues are never taken
+// in opposite orders by queues merging into each other
+var merging sync.Mutex
+
+// Merge moves all the buffered events of other to the queue and leaves other empty, e.g.
+// to combine the partial queues of a scatter-gather before emission. The events are
+// heapified together with the buffered ones by the comparator of the queue, so other is
+// expected to sort events the same way, otherwise the events of other come out in the order
+// of the queue. Like Restore, Merge does not emit anything and does not check the events
+// against the options like WithDedup or WithMaxSize. The events of other are popped out
+// of its heap, so Merge costs O(n + m log m) for m events of other. Events being sent by
+// other at the moment stay with it. Merge does nothing if the queue is closed or other
+// is the queue itself
+func (es *EventQueue[T]) Merge(other *EventQueue[T]) {
+	if other == es {
+		return
+	}
+	merging.Lock()
+	defer merging.Unlock()
+
+	es.lockMerged()
+	defer es.lock.Unlock()
+	if es.closed {
+		return
+	}
+	other.lockMerged()
+	defer other.lock.Unlock()
+
+	items := make([]T, 0, other.bufferedUnprotected())
+	other.queue.age()
+	for other.queue.Len() > 0 {
+		items = append(items, other.removeUnprotected(0, false))
+	}
+	es.loadUnprotected(items)
+}
+
+// CountIf returns the number of buffered events that match, e.g. to alert on the events
+// past their deadline without draining the queue. It scans all the buffered events under
+// the lock, so it costs O(n). match is called with the queue lock held, so it must not
+// call the queue. Events being sent at the moment are not buffered anymore
+func (es *EventQueue[T]) CountIf(match func(T) bool) int {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+
+	count := 0
+	for _, item := range es.queue.data {
+		if match(item) {
+			count++
+		}
+	}
+	return count
+}
+
+// Contains tells whether any buffered event matches. It scans all the buffered
+// events under the lock, so it costs O(n), see ContainsKey for an O(1) lookup.
+// Events being sent at the moment are not buffered anymore
+func (es *EventQueue[T]) Contains(match func(T) bool) bool {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+
+	for _, item := range es.queue.data {
+		if match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsKey tells whether an event with the key is buffered, using the key index
+// of WithDedup. Without WithDedup there is no index and ContainsKey returns false
+func (es *EventQueue[T]) ContainsKey(key string) bool {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	_, ok := es.keys[key]
+	return ok
+}
+
+// Remove removes the smallest buffered event that matches, e.g. an event that is
+// canceled before it is emitted, and returns it. The second value is false if no
+// buffered event matches. Finding the event scans the queue, so it costs O(n).
+// A removed event does not fill a sequence gap, see WithSequence
+func (es *EventQueue[T]) Remove(match func(T) bool) (T, bool) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+
+	found := es.findUnprotected(match)
+	if found < 0 {
+		var zero T
+		return zero, false
+	}
+	return es.removeUnprotected(found, false), true
+}
+
+// Update applies mutate to the smallest buffered event that matches, e.g. when
+// the priority of the event changes, and restores the order of the queue in O(log n).
+// mutate returns the updated event, for pointer events it may change the event
+// in place and return it as is. Finding the event scans the queue, so it costs O(n).
+// Update returns false if no buffered event matches
+func (es *EventQueue[T]) Update(match func(T) bool, mutate func(T) T) bool {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+
+	found := es.findUnprotected(match)
+	if found < 0 {
+		return false
+	}
+	es.replaceUnprotected(found, mutate)
+	return true
+}
+
+// CompareAndPush upserts an event by key: if a buffered event has the key of item,
+// item replaces it only if newer(existing, item) returns true and is dropped otherwise.
+// If no buffered event has the key, item is pushed like Push does. Either way item
+// goes through the checks of Push, e.g. WithMonotonicEmission, WithDuplicateSequence
+// and WithMaxBytes, is counted in Stats and reported to the OnPush hook; a rejected
+// replacement leaves the existing event buffered.
+// The events are found through an index by key that is built on the first call and
+// kept up to date in O(1) per heap move, so key must be the same in every call.
+// Of several buffered events with the same key one is replaced.
+// CompareAndPush reports whether item is accepted and panics if the queue is closed
+func (es *EventQueue[T]) CompareAndPush(item T, key func(T) string, newer func(existing, incoming T) bool) bool {
+	accepted := false
+	es.lockMerged()
+	defer func() {
+		if accepted {
+			es.notifyPush(item)
+		}
+	}()
+	defer es.unlock()
+
+	if es.closed {
+		panic(ErrClosed)
+	}
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+	if es.queue.index == nil {
+		es.queue.buildIndex(key)
+	}
+	found, ok := es.queue.index[key(item)]
+	if !ok {
+		accepted, _ = es.admitUnprotected(item)
+		return accepted
+	}
+	existing := es.queue.data[found]
+	if !newer(existing, item) {
+		return false
+	}
+	// the existing event makes no room for item and is no duplicate of it
+	es.removeUnprotected(found, false)
+	if accepted, _ = es.admitUnprotected(item); !accepted && !es.closed {
+		es.insertUnprotected(existing, false)
+	}
+	return accepted
+}
+
+// replaceUnprotected replaces the event at index found of the heap with the one
+// returned by mutate and restores the order
+func (es *EventQueue[T]) replaceUnprotected(found int, mutate func(T) T) {
+	if es.dedup != nil {
+		delete(es.keys, es.dedup(es.queue.data[found]))
+	}
+	if es.sizer != nil {
+		es.sizeBytes -= es.sizer(es.queue.data[found])
+	}
+	es.weight -= es.weightUnprotected(es.queue.data[found])
+	es.countSequenceUnprotected(es.queue.data[found], -1)
+	es.queue.unindex(found)
+	es.queue.data[found] = mutate(es.queue.data[found])
+	es.queue.setIndex(found)
+	es.countSequenceUnprotected(es.queue.data[found], 1)
+	if es.sizer != nil {
+		es.sizeBytes += es.sizer(es.queue.data[found])
+	}
+	es.weight += es.weightUnprotected(es.queue.data[found])
+	if es.dedup != nil {
+		es.keys[es.dedup(es.queue.data[found])] = struct{}{}
+	}
+	es.queue.fix(found)
+	es.pageInUnprotected()
+	if es.timestamp != nil {
+		es.holdUnprotected()
+	}
+}
+
+// findUnprotected returns the index of the smallest buffered event that matches, or -1
+func (es *EventQueue[T]) findUnprotected(match func(T) bool) int {
+	found := -1
+	for i, item := range es.queue.data {
+		if match(item) && (found < 0 || es.queue.Less(i, found)) {
+			found = i
+		}
+	}
+	return found
+}
+
+// PopN pops up to n smallest events out of the queue and returns them in sorted order.
+// It returns fewer events if the queue holds fewer. The events are not sent to
+// output channel, so PopN is a pull-based alternative to the channel
+func (es *EventQueue[T]) PopN(n int) []T {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return es.popNUnprotected(n)
+}
+
+// PopUntil pops all the events that sort before boundary out of the queue and returns
+// them in sorted order, e.g. the events below the watermark of a stream processor.
+// boundary is compared with the comparator of the queue, an event equal to it stays
+// buffered. Like PopN it never touches output channel
+func (es *EventQueue[T]) PopUntil(boundary T) []T {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	var items []T
+	es.queue.age()
+	for es.queue.Len() > 0 && es.queue.comparator.Less(es.queue.data[0], boundary) {
+		items = append(items, es.popUnprotected())
+	}
+	return items
+}
+
+// Next pops the smallest event out of the queue, it returns false if the queue is empty.
+// Like PopN it ignores emitThreshold and never touches output channel, so consumers
+// can pull events one by one at their own pace
+func (es *EventQueue[T]) Next() (T, bool) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	if es.queue.Len() == 0 {
+		var zero T
+		return zero, false
+	}
+	return es.popUnprotected(), true
+}
+
+// Drain pops all the buffered events out of the queue and returns them in sorted order,
+// leaving the queue empty. Unlike Flush it never touches output channel, so it helps
+// to switch to pull-based consumption, e.g. at shutdown
+func (es *EventQueue[T]) Drain() []T {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return es.popNUnprotected(es.bufferedUnprotected())
+}
+
+func (es *EventQueue[T]) popNUnprotected(n int) []T {
+	if n > es.bufferedUnprotected() {
+		n = es.bufferedUnprotected()
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	items := make([]T, n)
+	for i := range items {
+		items[i] = es.popUnprotected()
+	}
+	return items
+}
+
+func (es *EventQueue[T]) pushUnprotected(item T) {
+	es.seeUnprotected(item)
+	es.urgeUnprotected(item)
+	es.insertUnprotected(item, false)
+}
+
+// insertUnprotected adds an event to the heap. An oldest event goes before the events
+// equal to it, which matters with WithStableOrder when events are put back to the queue
+func (es *EventQueue[T]) insertUnprotected(item T, oldest bool) {
+	// with holdFloor the timer depends on the queue length too
+	reschedule := es.timestamp != nil && (es.holdFloor > 0 || oldest || es.queue.precedes(item))
+	if oldest {
+		es.queue.pushOldest(item)
+	} else {
+		es.queue.push(item)
+	}
+	es.lenChangedUnprotected()
+	if es.sizer != nil {
+		es.sizeBytes += es.sizer(item)
+	}
+	es.weight += es.weightUnprotected(item)
+	es.countSequenceUnprotected(item, 1)
+	if es.dedup != nil {
+		es.keys[es.dedup(item)] = struct{}{}
+	}
+	es.spillUnprotected()
+	if reschedule {
+		es.holdUnprotected()
+	}
+}
+
+func (es *EventQueue[T]) popUnprotected() T {
+	return es.removeUnprotected(0, true)
+}
+
+// removeUnprotected removes the event at index i of the heap. The next expected
+// sequence (see WithSequence) moves past the event only if it is emitted
+func (es *EventQueue[T]) removeUnprotected(i int, emitted bool) T {
+	if i == 0 {
+		// the smallest event may have changed with aging
+		es.queue.age()
+	}
+	item := es.queue.remove(i)
+	es.pageInUnprotected()
+	es.lenChangedUnprotected()
+	if es.maxSize > 0 || es.maxBytes > 0 || es.blockHigh > 0 {
+		// wake up pushes waiting for room
+		es.idle.Broadcast()
+	}
+	if es.sizer != nil {
+		es.sizeBytes -= es.sizer(item)
+	}
+	es.weight -= es.weightUnprotected(item)
+	es.countSequenceUnprotected(item, -1)
+	if es.dedup != nil {
+		delete(es.keys, es.dedup(item))
+	}
+	if emitted {
+		es.advanceUnprotected(item)
+		if es.reorder || es.monotonic {
+			es.lastEmitted, es.emittedAny = item, true
+		}
+	}
+	if es.timestamp != nil {
+		es.holdUnprotected()
+	}
+	return item
+}
+
+// makeRoomUnprotected enforces WithBlockingBounds, WithMaxSize and WithMaxBytes before
+// an event is added and reports whether the event may be added. ErrFull is returned if an event is dropped.
+// Under Block policy it waits for room if wait is true, until ctx is done
+// or the queue is closed
+func (es *EventQueue[T]) makeRoomUnprotected(ctx context.Context, wait bool, item T) (bool, error) {
+	if es.blockHigh > 0 {
+		if accepted, err := es.waitBoundsUnprotected(ctx, wait, item); !accepted {
+			return false, err
+		}
+	}
+	if !es.fullUnprotected(item) {
+		return true, nil
+	}
+	if es.maxBytes > 0 && es.sizer(item) > es.maxBytes {
+		// there is never room for it
+		es.dropUnprotected(item, DropOverflow)
+		return false, ErrFull
+	}
+
+	switch es.overflow {
+	case DropOldest:
+		for es.fullUnprotected(item) {
+			es.dropUnprotected(es.popUnprotected(), DropOverflow)
+		}
+		return true, ErrFull
+	case Block:
+		if !wait {
+			es.dropUnprotected(item, DropOverflow)
+			return false, ErrFull
+		}
+		for es.fullUnprotected(item) {
+			if err := canceled(ctx); err != nil {
+				return false, err
+			}
+			es.idle.Wait()
+			// Close may have drained the queue while waiting
+			if es.closed {
+				return false, ErrClosed
+			}
+		}
+		return true, nil
+	default:
+		es.dropUnprotected(item, DropOverflow)
+		return false, ErrFull
+	}
+}
+
+// fullUnprotected tells whether there is no room for an event
+// under WithMaxSize or WithMaxBytes
+func (es *EventQueue[T]) fullUnprotected(item T) bool {
+	return (es.maxSize > 0 && es.bufferedUnprotected() >= es.maxSize) ||
+		(es.maxBytes > 0 && es.sizeBytes+es.sizer(item) > es.maxBytes)
+}
+
+// waitBoundsUnprotected waits while pushes are blocked by WithBlockingBounds,
+// until ctx is done or the queue is closed. If wait is false the event is dropped
+// with ErrFull instead
+func (es *EventQueue[T]) waitBoundsUnprotected(ctx context.Context, wait bool, item T) (bool, error) {
+	for {
+		undelivered := es.bufferedUnprotected() + len(es.pending) + es.sending
+		if undelivered >= es.blockHigh {
+			es.blocked = true
+		} else if undelivered <= es.blockLow {
+			es.blocked = false
+		}
+		if !es.blocked {
+			return true, nil
+		}
+
+		if !wait {
+			es.dropUnprotected(item, DropOverflow)
+			return false, ErrFull
+		}
+		if err := canceled(ctx); err != nil {
+			return false, err
+		}
+		es.idle.Wait()
+		// Close may have drained the queue while waiting
+		if es.closed {
+			return false, ErrClosed
+		}
+	}
+}
+
+// takeUnprotected pops the smallest event for emission. With WithExpiry an expired
+// event is queued for onExpired instead
+func (es *EventQueue[T]) takeUnprotected() {
+	item := es.popUnprotected()
+	if es.deadline != nil && es.deadline(item).Before(time.Now()) {
+		es.stats.expired.Add(1)
+		if es.onExpired != nil {
+			es.expired = append(es.expired, item)
+		}
+		return
+	}
+	es.pending = append(es.pending, item)
+}
+
+// dropUnprotected counts an event dropped because the queue is full and queues it
+// for the OnDrop hook, which is called by unlock
+func (es *EventQueue[T]) dropUnprotected(item T, reason DropReason) {
+	if reason != DropCleared {
+		es.stats.dropped.Add(1)
+	}
+	if es.onDrop != nil {
+		es.drops = append(es.drops, droppedEvent[T]{item: item, reason: reason})
+	}
+}
+
+// dropTimedOutEvents reports the events given up by WithSendTimeout.
+// It is called by the emitting goroutine with es.lock released
+func (es *EventQueue[T]) dropTimedOutEvents(items []T) {
+	es.lock.Lock()
+	defer es.unlock()
+
+	es.timedOut += len(items)
+	for _, item := range items {
+		es.dropUnprotected(item, DropTimeout)
+	}
+}
+
+// unlock releases es.lock and then calls the OnDrop, OnLate, OnDuplicate and OnExpired
+// hooks for the events dropped, rejected, detected or expired meanwhile, so that
+// the hooks run outside the lock
+func (es *EventQueue[T]) unlock() {
+	drops, lates, duplicates, expired := es.drops, es.lates, es.duplicates, es.expired
+	es.drops, es.lates, es.duplicates, es.expired = nil, nil, nil, nil
+	es.lock.Unlock()
+
+	for _, item := range expired {
+		es.onExpired(item)
+	}
+	for _, item := range lates {
+		es.onLate(item)
+	}
+	for _, item := range duplicates {
+		es.onDuplicate(item)
+	}
+	for _, dropped := range drops {
+		es.onDrop(dropped.item, dropped.reason)
+	}
+}
+
+// lateUnprotected tells whether an event sorts before the last emitted event,
+// so it must be rejected to keep the emission monotonic, see WithMonotonicEmission.
+// A rejected event goes to onLate if it is set, otherwise it is dropped
+func (es *EventQueue[T]) lateUnprotected(item T) bool {
+	if !es.monotonic || !es.emittedAny || !es.queue.comparator.Less(item, es.lastEmitted) {
+		return false
+	}
+	es.stats.late.Add(1)
+	if es.onLate != nil {
+		es.lates = append(es.lates, item)
+	} else {
+		es.dropUnprotected(item, DropLate)
+	}
+	return true
+}
+
+// duplicateSequenceUnprotected reports an event with the sequence number of a buffered
+// event to onDuplicate and tells whether it must be dropped, see WithDuplicateSequence
+func (es *EventQueue[T]) duplicateSequenceUnprotected(item T) bool {
+	if es.duplicateSequence == nil || es.sequences[es.duplicateSequence(item)] == 0 {
+		return false
+	}
+	es.stats.duplicates.Add(1)
+	if es.onDuplicate != nil {
+		es.duplicates = append(es.duplicates, item)
+	}
+	return es.dropDuplicates
+}
+
+// countSequenceUnprotected counts a buffered event by its sequence number,
+// delta is 1 when the event is added and -1 when it leaves the queue
+func (es *EventQueue[T]) countSequenceUnprotected(item T, delta int) {
+	if es.duplicateSequence == nil {
+		return
+	}
+	sequence := es.duplicateSequence(item)
+	if es.sequences[sequence] += delta; es.sequences[sequence] <= 0 {
+		delete(es.sequences, sequence)
+	}
+}
+
+// duplicateUnprotected tells whether an event with the same key is buffered, see WithDedup,
+// or is pushed within dedupTTL, see WithDedupTTL
+func (es *EventQueue[T]) duplicateUnprotected(item T) bool {
+	if es.seenKey != nil {
+		es.evictSeenUnprotected(time.Now())
+		if _, ok := es.seen[es.seenKey(item)]; ok {
+			return true
+		}
+	}
+	if es.dedup == nil {
+		return false
+	}
+	_, ok := es.keys[es.dedup(item)]
+	return ok
+}
+
+// seenEntry is a key pushed at the given time, see WithDedupTTL
+type seenEntry struct {
+	key    string
+	pushed time.Time
+}
+
+// seeUnprotected remembers the key of a pushed event for dedupTTL. A key seen already
+// keeps its time, so the window is not extended
+func (es *EventQueue[T]) seeUnprotected(item T) {
+	if es.seenKey == nil {
+		return
+	}
+	key := es.seenKey(item)
+	if _, ok := es.seen[key]; ok {
+		return
+	}
+	es.seen[key] = struct{}{}
+	es.seenLog = append(es.seenLog, seenEntry{key: key, pushed: time.Now()})
+}
+
+// evictSeenUnprotected forgets the keys pushed dedupTTL ago or earlier. All the keys
+// live for the same dedupTTL, so they expire in push order
+func (es *EventQueue[T]) evictSeenUnprotected(now time.Time) {
+	expired := 0
+	for expired < len(es.seenLog) && now.Sub(es.seenLog[expired].pushed) >= es.dedupTTL {
+		delete(es.seen, es.seenLog[expired].key)
+		expired++
+	}
+	if expired > 0 {
+		// copied, so that the backing array does not keep growing behind the front
+		es.seenLog = append(es.seenLog[:0], es.seenLog[expired:]...)
+	}
+}
+
+// collectUnprotected moves the events that are due for emission to pending
+// after pushing the given number of events
+func (es *EventQueue[T]) collectUnprotected(pushed int) {
+	urgent := es.urgent
+	es.urgent = 0
+	if es.pull || es.paused || es.manual {
+		return
+	}
+	// the events due already are taken first, so that they are not counted again
+	es.takeCoalescedUnprotected()
+	taken := len(es.pending)
+	for n := es.dueUnprotected(es.bufferedUnprotected(), pushed, es.weight); n > 0 && es.heavyUnprotected(0) && es.readyUnprotected(es.queue.data[0]) && es.settledUnprotected(es.queue.data[0]); n-- {
+		es.takeUnprotected()
+	}
+	for es.queue.Len() > 0 && es.readyUnprotected(es.queue.data[0]) && es.heldUnprotected(es.queue.data[0]) <= 0 {
+		es.takeUnprotected()
+	}
+	for urgent > len(es.pending)-taken && es.queue.Len() > 0 && es.readyUnprotected(es.queue.data[0]) {
+		es.takeUnprotected()
+	}
+}
+
+// urgeUnprotected counts a pushed event that matches immediate, see WithImmediate
+func (es *EventQueue[T]) urgeUnprotected(item T) {
+	if es.immediate != nil && es.immediate(item) {
+		es.urgent++
+	}
+}
+
+// heldUnprotected returns how long the event is still to be held before it is emitted
+// regardless of emitThreshold, see WithMinHold.
+// Without it events are held until emitThreshold is reached
+func (es *EventQueue[T]) heldUnprotected(item T) time.Duration {
+	if es.releaseAfter <= 0 {
+		return math.MaxInt64
+	}
+	return es.releaseAfter - time.Since(es.timestamp(item))
+}
+
+// settledUnprotected tells whether the event is held long enough to be emitted
+// by reaching emitThreshold, see WithHoldBounds
+func (es *EventQueue[T]) settledUnprotected(item T) bool {
+	return es.holdFloor <= 0 || time.Since(es.timestamp(item)) >= es.holdFloor
+}
+
+// holdUnprotected schedules holdTimer for the moment the smallest event
+// is held long enough. Must be called whenever the smallest event changes,
+// and with holdFloor whenever the queue length changes
+func (es *EventQueue[T]) holdUnprotected() {
+	if es.queue.Len() == 0 || !es.readyUnprotected(es.queue.data[0]) {
+		// a sequence gap holds the events anyway, the event filling it reschedules
+		if es.holdTimer != nil {
+			es.holdTimer.Stop()
+		}
+		return
+	}
+
+	wait := es.heldUnprotected(es.queue.data[0])
+	if es.holdFloor > 0 && es.dueUnprotected(es.bufferedUnprotected(), es.bufferedUnprotected(), es.weight) > 0 {
+		// emitThreshold is reached, but the event is not held long enough yet.
+		// Once it is, Push emits it as usual
+		if floor := es.holdFloor - time.Since(es.timestamp(es.queue.data[0])); floor > 0 && floor < wait {
+			wait = floor
+		}
+	}
+	if wait == math.MaxInt64 {
+		if es.holdTimer != nil {
+			es.holdTimer.Stop()
+		}
+		return
+	}
+	if es.holdTimer == nil {
+		es.holdTimer = time.AfterFunc(wait, es.releaseHeld)
+		return
+	}
+	es.holdTimer.Reset(wait)
+}
+
+// releaseHeld is run by holdTimer to emit the events held long enough
+func (es *EventQueue[T]) releaseHeld() {
+	es.lock.Lock()
+	defer es.unlock()
+
+	if es.closed {
+		return
+	}
+	es.beginEmit(true, nil)
+	if es.closed {
+		es.endEmitUnprotected()
+		return
+	}
+	// the events blocked by holdFloor are emitted as if they were pushed just now
+	es.collectUnprotected(es.bufferedUnprotected())
+	es.sendUnprotected(nil)
+}
+
+func (es *EventQueue[T]) clearUnprotected() {
+	if es.onDrop != nil {
+		es.unspillUnprotected()
+		for _, item := range es.queue.data {
+			es.dropUnprotected(item, DropCleared)
+		}
+	}
+	es.idle.Broadcast()
+	if es.dedup != nil {
+		es.keys = make(map[string]struct{})
+	}
+	if es.duplicateSequence != nil {
+		es.sequences = make(map[uint64]int)
+	}
+	es.sizeBytes = 0
+	es.weight = 0
+	if es.spill != nil {
+		es.spill.clear()
+	}
+	if cap(es.queue.data) > es.initialCapacity && !es.reuse {
+		es.queue.data = make([]T, 0, es.initialCapacity)
+		es.queue.meta = nil
+	} else {
+		var zero T
+		for i := range es.queue.data {
+			es.queue.data[i] = zero
+		}
+		es.queue.data = es.queue.data[:0]
+		es.queue.meta = es.queue.meta[:0]
+	}
+	if es.queue.index != nil {
+		es.queue.index = make(map[string]int)
+	}
+	es.lenChangedUnprotected()
+}
+
+// collectAllUnprotected moves all the events to pending. With WithFlushComparator
+// the pending events are sorted by the flush comparator then
+func (es *EventQueue[T]) collectAllUnprotected() {
+	if es.pull {
+		return
+	}
+	for es.queue.Len() > 0 {
+		es.takeUnprotected()
+	}
+	if es.flushComparator != nil && len(es.pending) > 0 {
+		comparator := es.flushComparator
+		sort.SliceStable(es.pending, func(i, j int) bool { return comparator.Less(es.pending[i], es.pending[j]) })
+		es.flushSorted = true
+	}
+}
+
+// flushIdle is run by idleTimer when nothing is emitted for flushInterval
+func (es *EventQueue[T]) flushIdle() {
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.closed {
+		return
+	}
+	if es.paused || es.manual {
+		es.idleTimer.Reset(es.flushInterval)
+		return
+	}
+	es.beginEmit(true, nil)
+	if es.closed {
+		es.endEmitUnprotected()
+		return
+	}
+	es.stats.flushes.Add(1)
+	es.collectAllUnprotected()
+	es.sendUnprotected(nil)
+	es.idleTimer.Reset(es.flushInterval)
+}
+
+// heartbeat is run by heartbeatTimer, it sends a beat unless an event is emitted
+// within heartbeatInterval, and then waits for heartbeatInterval since the last
+// emission again
+func (es *EventQueue[T]) heartbeat() {
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.closed {
+		return
+	}
+	if idle := time.Since(es.lastEmitAt); idle < es.heartbeatInterval {
+		es.heartbeatTimer.Reset(es.heartbeatInterval - idle)
+		return
+	}
+	if !es.pull {
+		es.beginEmit(true, nil)
+		if es.closed {
+			es.endEmitUnprotected()
+			return
+		}
+		// not counted as emitted, so the next beat is due in heartbeatInterval too
+		es.sendAsideUnprotected(es.makeBeat(), closedChan)
+		es.endEmitUnprotected()
+	}
+	es.heartbeatTimer.Reset(es.heartbeatInterval)
+}
+
+// dueUnprotected returns the number of events to emit when the queue holds n events
+// after pushing the given number of events: one per pushed event by default, or as many
+// as needed to get down to lowWatermark.
+// With WithSequence the queue may have grown while waiting for a gap to be filled,
+// so it drains down to emitThreshold-1. In window mode only whole windows are due.
+// With WithWeight any event may be due once the events weigh emitThreshold,
+// the callers stop emitting when the weight gets below it, see heavyUnprotected
+func (es *EventQueue[T]) dueUnprotected(n, pushed, weight int) int {
+	if es.pull || es.paused || es.manual {
+		return 0
+	}
+	if es.weigher != nil {
+		if weight < es.emitThreshold {
+			return 0
+		}
+		return n
+	}
+	if n < es.emitThreshold {
+		return 0
+	}
+	if es.window {
+		return n - n%es.emitThreshold
+	}
+	if es.lowWatermark >= 0 && es.lowWatermark < es.emitThreshold {
+		return n - es.lowWatermark
+	}
+	if es.sequence != nil || pushed > n-es.emitThreshold+1 {
+		return n - es.emitThreshold + 1
+	}
+	return pushed
+}
+
+// heavyUnprotected tells whether the buffered events and an incoming event of the given
+// weight still weigh emitThreshold, so the smallest one is due. It is always true without WithWeight
+func (es *EventQueue[T]) heavyUnprotected(incoming int) bool {
+	return es.weigher == nil || es.weight+incoming >= es.emitThreshold
+}
+
+// weightUnprotected returns the weight of an event, 0 without WithWeight
+func (es *EventQueue[T]) weightUnprotected(item T) int {
+	if es.weigher == nil {
+		return 0
+	}
+	return es.weigher(item)
+}
+
+// readyUnprotected tells whether the event may be emitted. With WithSequence only
+// the next expected event may go, or a late one that is behind it already
+func (es *EventQueue[T]) readyUnprotected(item T) bool {
+	return es.sequence == nil || es.sequence(item) <= es.nextSequence
+}
+
+// advanceUnprotected moves the next expected sequence past an event leaving the queue
+func (es *EventQueue[T]) advanceUnprotected(item T) {
+	if es.sequence == nil {
+		return
+	}
+	if sequence := es.sequence(item); sequence >= es.nextSequence {
+		es.nextSequence = sequence + 1
+	}
+}
+
+// emitUnprotected sends pending events unless another goroutine is sending
+// them already. With WithBackgroundEmitter the emitter goroutine is woken up instead
+func (es *EventQueue[T]) emitUnprotected() {
+	if es.coalesceWindow > 0 {
+		es.coalesceUnprotected()
+		return
+	}
+	if es.wake != nil {
+		select {
+		case es.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	if es.beginEmit(false, nil) {
+		es.sendUnprotected(nil)
+	}
+}
+
+// coalesceUnprotected puts the pending events back to the heap and schedules them
+// to be sent at the end of the current window, see WithCoalesceWindow. They stay
+// in the heap meanwhile, so that Len, Snapshot, Clear and Drain see them
+func (es *EventQueue[T]) coalesceUnprotected() {
+	if len(es.pending) == 0 {
+		return
+	}
+	due := len(es.pending)
+	es.rebufferUnprotected()
+	es.coalesced += due
+	if es.coalescing {
+		return
+	}
+	es.coalescing = true
+	now := time.Now()
+	wait := now.Truncate(es.coalesceWindow).Add(es.coalesceWindow).Sub(now)
+	if es.coalesceTimer == nil {
+		es.coalesceTimer = time.AfterFunc(wait, es.emitCoalesced)
+		return
+	}
+	es.coalesceTimer.Reset(wait)
+}
+
+// takeCoalescedUnprotected moves the events due for the end of the window to pending
+func (es *EventQueue[T]) takeCoalescedUnprotected() {
+	// the length changes under the loop and caps coalesced, so it is reset first
+	due := es.coalesced
+	es.coalesced = 0
+	for ; due > 0 && es.queue.Len() > 0; due-- {
+		es.takeUnprotected()
+	}
+}
+
+// emitCoalesced is run by coalesceTimer at the end of the window
+func (es *EventQueue[T]) emitCoalesced() {
+	es.lockMerged()
+	defer es.unlock()
+
+	es.coalescing = false
+	if es.closed {
+		return
+	}
+	es.beginEmit(true, nil)
+	if es.closed {
+		es.endEmitUnprotected()
+		return
+	}
+	es.takeCoalescedUnprotected()
+	es.sendUnprotected(nil)
+}
+
+// emitInBackground is the emitter goroutine of WithBackgroundEmitter.
+// It runs until Close closes wake
+func (es *EventQueue[T]) emitInBackground() {
+	for range es.wake {
+		es.lock.Lock()
+		es.beginEmit(true, nil)
+		es.sendUnprotected(nil)
+		es.unlock()
+	}
+}
+
+// beginEmit makes the calling goroutine the one that sends pending events.
+// Must be called with es.lock held.
+// Only one goroutine sends at a time. If some goroutine is sending already,
+// beginEmit leaves pending events to it and returns false, unless wait is true:
+// then it waits until the other goroutine is done or until done is closed
+func (es *EventQueue[T]) beginEmit(wait bool, done <-chan struct{}) bool {
+	for es.emitting {
+		if !wait || isDone(done) {
+			return false
+		}
+		es.idle.Wait()
+	}
+	es.emitting = true
+
+	return true
+}
+
+// sendUnprotected sends pending events to output in order and returns
+// the number of events sent. Must be called with es.lock held by the goroutine
+// that passed beginEmit; the lock is released while events are being sent and
+// acquired again before return.
+// If done is closed before all the events are sent, the rest stays in es.pending
+// and the caller must rebuffer it before releasing the lock. Without done only a failing
+// sink stops the send (see NewEventQueueErrFunc), then the rest is rebuffered right away
+func (es *EventQueue[T]) sendUnprotected(done <-chan struct{}) int {
+	defer es.endEmitUnprotected()
+
+	sent := 0
+	for len(es.pending) > 0 {
+		// events pending at the moment go at once,
+		// the ones popped meanwhile by other goroutines go next
+		batch := es.pending
+		es.pending = nil
+
+		es.sending = len(batch)
+		delivered := es.sendBatch(batch, done)
+		n := delivered + es.timedOut
+		es.timedOut = 0
+		es.sending = 0
+		if es.blockHigh > 0 {
+			// wake up pushes waiting for the events to be delivered
+			es.idle.Broadcast()
+		}
+		sent += n
+		es.stats.emitted.Add(uint64(delivered))
+		es.watermarkUnprotected(batch, n)
+		if delivered > 0 {
+			es.lastEmitAt = time.Now()
+			if es.idleTimer != nil {
+				es.idleTimer.Reset(es.flushInterval)
+			}
+		}
+		if n < len(batch) {
+			es.pending = append(batch[n:], es.pending...)
+			if done == nil {
+				es.rebufferUnprotected()
+			}
+			return sent
+		}
+		if es.reusePending && es.pending == nil {
+			var zero T
+			for i := range batch {
+				batch[i] = zero
+			}
+			es.pending = batch[:0]
+		}
+	}
+
+	return sent
+}
+
+// sendAsideUnprotected sends an event that is not buffered, the sentinel of WithCloseSentinel
+// or a beat of WithHeartbeat, with es.lock released. Must be called by the goroutine
+// that passed beginEmit
+func (es *EventQueue[T]) sendAsideUnprotected(item T, done <-chan struct{}) {
+	output := es.output
+	es.lock.Unlock()
+	defer func() {
+		es.lock.Lock()
+		// the event is not buffered, see timedOut
+		es.timedOut = 0
+	}()
+
+	output.send([]T{item}, done)
+}
+
+// endEmitUnprotected lets other goroutines send events
+func (es *EventQueue[T]) endEmitUnprotected() {
+	es.emitting = false
+	es.idle.Broadcast()
+}
+
+// sendBatch sends events to output with es.lock released. The lock is acquired
+// again even if the send panics, e.g. when the client closed the channel.
+// The OnEmit hook is called here too, so it runs outside the lock
+func (es *EventQueue[T]) sendBatch(items []T, done <-chan struct{}) int {
+	// AddOutput may replace the output meanwhile, the new one is used by the next batch
+	output := es.output
+	comparator, sorted := es.queue.comparator, es.batchOutput && es.queue.aging == nil
+	if es.flushSorted {
+		// sorted by the flush comparator already
+		sorted, es.flushSorted = false, false
+	}
+	es.lock.Unlock()
+	defer es.lock.Lock()
+
+	if sorted {
+		// the events are popped in order, but an event pushed while the batch
+		// was pending may sort before the events popped earlier
+		sort.SliceStable(items, func(i, j int) bool { return comparator.Less(items[i], items[j]) })
+	}
+	if es.onEmitBatch != nil {
+		es.onEmitBatch(items)
+	}
+	if es.onEmit != nil {
+		for _, item := range items {
+			es.onEmit(item)
+		}
+	}
+	return output.send(items, done)
+}
+
+// notifyPush calls the OnPush hook, if any. It must be called with es.lock released
+func (es *EventQueue[T]) notifyPush(item T) {
+	if es.onPush != nil {
+		es.onPush(item)
+	}
+}
+
+// rebufferUnprotected puts pending events back to the queue. They were
+// popped before any buffered event equal to them, so they keep going first
+func (es *EventQueue[T]) rebufferUnprotected() {
+	for i := len(es.pending) - 1; i >= 0; i-- {
+		es.insertUnprotected(es.pending[i], true)
+	}
+	es.pending = nil
+}
+
+// wakeUpOnDone wakes goroutines waiting for their turn to send events once done
+// is closed, so that they notice it. The returned function stops the watch
+func (es *EventQueue[T]) wakeUpOnDone(done <-chan struct{}) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			es.lock.Lock()
+			es.idle.Broadcast()
+			es.lock.Unlock()
+		case <-stopped:
+		}
+	}()
+
+	return func() { close(stopped) }
+}
+
+// defaultCapacity is the initial capacity of the queue buffer, see WithInitialCapacity
+const defaultCapacity = 10
+
+// closedChan is used as done channel to send events without blocking
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// eventPriorityQueue is just an implementation of PriorityQueue (MinHeap) for events of type T.
+// If stable or aging is set, meta holds the insertion order and the arrival time
+// of the events in data, see WithStableOrder and WithAging
+type eventPriorityQueue[T any] struct {
+	data       []T
+	comparator Comparator[T]
+
+	stable bool
+	aging  func(T, time.Duration) int
+	// now is the moment the events are aged at, see age
+	now            time.Time
+	meta           []eventMeta
+	newest, oldest int64
+
+	// inOrder is set while data is sorted, which is a valid heap too. Then an event
+	// that does not sort before the last one is appended without sifting and the smallest
+	// event is popped off the front without comparisons, so mostly sorted streams are cheap.
+	// Popping off the front shrinks the capacity of data, so it is skipped with reuse
+	// and with index, which would have to shift every position
+	inOrder bool
+	reuse   bool
+
+	// index maps the keys of CompareAndPush to the positions of the events in data.
+	// It is built on the first CompareAndPush and kept up to date by Swap and the heap
+	// operations. Of several events with the same key only one is indexed
+	index    map[string]int
+	indexKey func(T) string
+}
+
+type eventMeta struct {
+	order   int64
+	arrived time.Time
+}
+
+func (pq eventPriorityQueue[T]) tracked() bool { return pq.stable || pq.aging != nil }
+
+func (pq eventPriorityQueue[T]) Len() int { return len(pq.data) }
+func (pq eventPriorityQueue[T]) Less(i, j int) bool {
+	if !pq.tracked() {
+		return pq.comparator.Less(pq.data[i], pq.data[j])
+	}
+	return pq.less(pq.data[i], pq.meta[i], pq.data[j], pq.meta[j])
+}
+func (pq eventPriorityQueue[T]) less(a T, am eventMeta, b T, bm eventMeta) bool {
+	if pq.aging != nil {
+		ap, bp := pq.aging(a, pq.now.Sub(am.arrived)), pq.aging(b, pq.now.Sub(bm.arrived))
+		if ap != bp {
+			return ap < bp
+		}
+	}
+	if pq.comparator.Less(a, b) {
+		return true
+	}
+	if !pq.stable || pq.comparator.Less(b, a) {
+		return false
+	}
+	return am.order < bm.order
+}
+func (pq eventPriorityQueue[T]) Swap(i, j int) {
+	pq.data[i], pq.data[j] = pq.data[j], pq.data[i]
+	if pq.tracked() {
+		pq.meta[i], pq.meta[j] = pq.meta[j], pq.meta[i]
+	}
+	if pq.index != nil {
+		pq.setIndex(i)
+		pq.setIndex(j)
+	}
+}
+
+// buildIndex indexes the events in data by key, see index
+func (pq *eventPriorityQueue[T]) buildIndex(key func(T) string) {
+	pq.index = make(map[string]int, len(pq.data))
+	pq.indexKey = key
+	for i := range pq.data {
+		pq.setIndex(i)
+	}
+}
+
+// setIndex points the key of the event at i to i
+func (pq eventPriorityQueue[T]) setIndex(i int) {
+	if pq.index != nil {
+		pq.index[pq.indexKey(pq.data[i])] = i
+	}
+}
+
+// unindex drops the key of the event at i, unless it points to another event
+func (pq eventPriorityQueue[T]) unindex(i int) {
+	if pq.index == nil {
+		return
+	}
+	key := pq.indexKey(pq.data[i])
+	if j, ok := pq.index[key]; ok && j == i {
+		delete(pq.index, key)
+	}
+}
+
+// The heap operations below mirror container/heap, but work with T directly,
+// so that events are not boxed into interface{} on every push and pop
+
+func (pq *eventPriorityQueue[T]) init() {
+	n := pq.Len()
+	pq.inOrder = n <= 1 && pq.aging == nil
+	for i := n/2 - 1; i >= 0; i-- {
+		pq.down(i, n)
+	}
+}
+
+func (pq *eventPriorityQueue[T]) push(item T) {
+	pq.data = append(pq.data, item)
+	if pq.tracked() {
+		pq.meta = append(pq.meta, pq.nextMeta())
+	}
+	pq.setIndex(len(pq.data) - 1)
+	pq.settle()
+}
+
+// settle sifts up the event appended last, unless it keeps data sorted, see inOrder
+func (pq *eventPriorityQueue[T]) settle() {
+	n := pq.Len() - 1
+	if n == 0 {
+		// aging changes the priorities over time, so sorted data does not stay sorted
+		pq.inOrder = pq.aging == nil
+	}
+	if pq.inOrder && (n == 0 || !pq.Less(n, n-1)) {
+		return
+	}
+	pq.inOrder = false
+	pq.up(n)
+}
+
+// remove removes the event at index i. The vacated slot is zeroed, so that
+// the backing array does not keep the event alive
+func (pq *eventPriorityQueue[T]) remove(i int) T {
+	n := pq.Len() - 1
+	if i == 0 && n > 0 && pq.inOrder && !pq.reuse && pq.index == nil {
+		item := pq.data[0]
+		var zero T
+		pq.data[0] = zero
+		pq.data = pq.data[1:]
+		if pq.tracked() {
+			pq.meta = pq.meta[1:]
+		}
+		return item
+	}
+	if n != i {
+		pq.inOrder = false
+		pq.Swap(i, n)
+		if !pq.down(i, n) {
+			pq.up(i)
+		}
+	}
+	pq.unindex(n)
+	item := pq.data[n]
+	var zero T
+	pq.data[n] = zero
+	pq.data = pq.data[:n]
+	if pq.tracked() {
+		pq.meta = pq.meta[:n]
+	}
+	return item
+}
+
+func (pq *eventPriorityQueue[T]) fix(i int) {
+	pq.inOrder = false
+	if !pq.down(i, pq.Len()) {
+		pq.up(i)
+	}
+}
+
+func (pq *eventPriorityQueue[T]) up(j int) {
+	for {
+		i := (j - 1) / 2 // parent
+		if i == j || !pq.Less(j, i) {
+			break
+		}
+		pq.Swap(i, j)
+		j = i
+	}
+}
+
+func (pq *eventPriorityQueue[T]) down(i0, n int) bool {
+	i := i0
+	for {
+		j1 := 2*i + 1
+		if j1 >= n || j1 < 0 { // j1 < 0 after int overflow
+			break
+		}
+		j := j1 // left child
+		if j2 := j1 + 1; j2 < n && pq.Less(j2, j1) {
+			j = j2 // right child
+		}
+		if !pq.Less(j, i) {
+			break
+		}
+		pq.Swap(i, j)
+		i = j
+	}
+	return i > i0
+}
+
+// nextMeta returns the metadata of a newly arriving event
+func (pq *eventPriorityQueue[T]) nextMeta() eventMeta {
+	pq.newest++
+	meta := eventMeta{order: pq.newest}
+	if pq.aging != nil {
+		meta.arrived = time.Now()
+	}
+	return meta
+}
+
+// age rebuilds the heap with the priorities of the events aged up to now,
+// since aging changes them over time, see WithAging
+func (pq *eventPriorityQueue[T]) age() {
+	if pq.aging != nil {
+		pq.now = time.Now()
+		pq.init()
+	}
+}
+
+// load adds events at once in their order and heapifies in O(n)
+func (pq *eventPriorityQueue[T]) load(items []T) {
+	pq.data = append(pq.data, items...)
+	if pq.tracked() {
+		for range items {
+			pq.meta = append(pq.meta, pq.nextMeta())
+		}
+	}
+	for i := len(pq.data) - len(items); i < len(pq.data); i++ {
+		pq.setIndex(i)
+	}
+	pq.init()
+}
+
+// partition takes the events for which take returns true out of data, keeps the order
+// of the rest and heapifies it back in O(n)
+func (pq *eventPriorityQueue[T]) partition(take func(T) bool) []T {
+	var taken []T
+	kept := 0
+	for i, item := range pq.data {
+		if take(item) {
+			taken = append(taken, item)
+			continue
+		}
+		pq.data[kept] = item
+		if pq.tracked() {
+			pq.meta[kept] = pq.meta[i]
+		}
+		kept++
+	}
+	var zero T
+	for i := kept; i < len(pq.data); i++ {
+		pq.data[i] = zero
+	}
+	pq.data = pq.data[:kept]
+	if pq.tracked() {
+		pq.meta = pq.meta[:kept]
+	}
+	if pq.index != nil {
+		pq.buildIndex(pq.indexKey)
+	}
+	pq.init()
+	return taken
+}
+
+// sorted returns a sorted copy of the events
+func (pq eventPriorityQueue[T]) sorted() []T {
+	sorted := pq
+	sorted.data = append([]T(nil), pq.data...)
+	sorted.meta = append([]eventMeta(nil), pq.meta...)
+	// the index belongs to data, not to the copy
+	sorted.index = nil
+	if !pq.inOrder {
+		sort.Sort(sorted)
+	}
+	return sorted.data
+}
+
+// sort sorts data in place, a sorted heap is a heap too
+func (pq *eventPriorityQueue[T]) sort() {
+	if !pq.inOrder {
+		sort.Sort(pq)
+		pq.inOrder = pq.aging == nil
+	}
+}
+
+// pushOldest pushes an event that goes before all the buffered events equal to it
+func (pq *eventPriorityQueue[T]) pushOldest(item T) {
+	if !pq.tracked() {
+		pq.push(item)
+		return
+	}
+	pq.data = append(pq.data, item)
+	pq.oldest--
+	meta := eventMeta{order: pq.oldest}
+	if pq.aging != nil {
+		meta.arrived = time.Now()
+	}
+	pq.meta = append(pq.meta, meta)
+	pq.setIndex(len(pq.data) - 1)
+	pq.settle()
+}
+
+// precedes tells whether a new event goes before the smallest buffered event
+func (pq eventPriorityQueue[T]) precedes(item T) bool {
+	if len(pq.data) == 0 {
+		return true
+	}
+	if pq.tracked() {
+		meta := eventMeta{order: pq.newest + 1, arrived: pq.now}
+		return pq.less(item, meta, pq.data[0], pq.meta[0])
+	}
+	return !pq.comparator.Less(pq.data[0], item)
+}
@@ -0,0 +1,507 @@
+package generic
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testEvent struct {
+	sequence uint64
+	content  int
+}
+
+var sequenceComparator ComparatorFunc[testEvent] = func(a, b testEvent) bool {
+	return a.sequence < b.sequence
+}
+
+func TestEventChannelEmitting(t *testing.T) {
+	// the comparator works with testEvent directly, no type assertions needed
+	var comparator ComparatorFunc[testEvent] = func(a, b testEvent) bool {
+		return a.sequence < b.sequence
+	}
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](3, ch, comparator)
+
+	events := []testEvent{
+		{sequence: 100, content: 10},
+		{sequence: 1, content: 12},
+		{sequence: 12, content: 15},
+		{sequence: 150, content: 151},
+	}
+	for _, event := range events {
+		queue.Push(event)
+	}
+	require.Equal(t, 2, queue.Len())
+	queue.Flush()
+	require.Equal(t, 0, queue.Len())
+
+	close(ch)
+
+	expectedSequence := []int{1, 2, 0, 3}
+	for i, expectedIndex := range expectedSequence {
+		item := <-ch
+		require.Equal(t, events[expectedIndex], item, "test case #%d", i)
+	}
+}
+
+func TestCompositeComparator(t *testing.T) {
+	type sourcedEvent struct {
+		timestamp int
+		source    string
+		sequence  int
+	}
+	byTimestamp := ComparatorFunc[sourcedEvent](func(a, b sourcedEvent) bool { return a.timestamp < b.timestamp })
+	bySource := ComparatorFunc[sourcedEvent](func(a, b sourcedEvent) bool { return a.source < b.source })
+	bySequence := ComparatorFunc[sourcedEvent](func(a, b sourcedEvent) bool { return a.sequence < b.sequence })
+
+	ch := make(chan sourcedEvent, 10)
+	queue := NewEventQueue[sourcedEvent](10, ch, CompositeComparator[sourcedEvent](byTimestamp, bySource, bySequence))
+	events := []sourcedEvent{
+		{timestamp: 2, source: "a", sequence: 1},
+		{timestamp: 1, source: "b", sequence: 2},
+		{timestamp: 1, source: "b", sequence: 1},
+		{timestamp: 1, source: "a", sequence: 3},
+		{timestamp: 2, source: "a", sequence: 0},
+	}
+	for _, event := range events {
+		queue.Push(event)
+	}
+	queue.Flush()
+
+	for _, expected := range []int{3, 2, 1, 4, 0} {
+		require.Equal(t, events[expected], <-ch)
+	}
+	require.False(t, CompositeComparator[sourcedEvent]().Less(events[0], events[1]))
+}
+
+func TestPointerEvents(t *testing.T) {
+	var comparator ComparatorFunc[*testEvent] = func(a, b *testEvent) bool {
+		return a.content > b.content
+	}
+	ch := make(chan *testEvent, 10)
+	queue := NewEventQueue[*testEvent](10, ch, comparator)
+
+	first, second := &testEvent{content: 1}, &testEvent{content: 2}
+	queue.Push(first)
+	queue.Push(second)
+	queue.Flush()
+
+	require.Same(t, second, <-ch)
+	require.Same(t, first, <-ch)
+}
+
+// waitEmitting blocks until some goroutine is sending events of the queue
+func waitEmitting[T any](queue *EventQueue[T]) {
+	for {
+		queue.lock.Lock()
+		emitting := queue.emitting
+		queue.lock.Unlock()
+		if emitting {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestPushDoesNotBlockWhileSending(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator)
+
+	go queue.Push(testEvent{sequence: 2})
+	waitEmitting(queue)
+
+	pushed := make(chan struct{})
+	go func() {
+		queue.Push(testEvent{sequence: 1})
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("Push is blocked by another goroutine sending to output channel")
+	}
+
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+}
+
+func TestPushWhileFlushing(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		queue.Flush()
+		close(flushed)
+	}()
+	waitEmitting(queue)
+
+	// the flushed events are out of the buffer already
+	for _, sequence := range []uint64{5, 4} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, 2, queue.Len())
+
+	for _, expected := range []uint64{1, 2, 3} {
+		require.Equal(t, expected, (<-ch).sequence)
+	}
+	<-flushed
+	require.Equal(t, []testEvent{{sequence: 4}, {sequence: 5}}, queue.Drain())
+}
+
+func TestFlushWaitsForPendingEvents(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator)
+
+	queue.Push(testEvent{sequence: 3})
+	go queue.Push(testEvent{sequence: 1})
+	waitEmitting(queue)
+	queue.Push(testEvent{sequence: 2})
+
+	flushed := make(chan struct{})
+	go func() {
+		queue.Flush()
+		close(flushed)
+	}()
+
+	for _, expected := range []uint64{1, 2, 3} {
+		require.Equal(t, expected, (<-ch).sequence)
+	}
+	<-flushed
+	require.Equal(t, 0, queue.Len())
+}
+
+func TestCloseWhilePushContextWaits(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithOwnedOutput[testEvent]())
+
+	go queue.Push(testEvent{sequence: 1})
+	waitEmitting(queue)
+
+	pushed := make(chan error, 1)
+	go func() { pushed <- queue.PushContext(context.Background(), testEvent{sequence: 2}) }()
+	// give PushContext and then Close a chance to start waiting for their turn
+	time.Sleep(10 * time.Millisecond)
+	go queue.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.sequence)
+	}
+	if err := <-pushed; err == nil {
+		require.Equal(t, []uint64{1, 2}, emitted)
+	} else {
+		require.Equal(t, ErrClosed, err)
+		require.Equal(t, []uint64{1}, emitted)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithFlushInterval[testEvent](20*time.Millisecond))
+	defer queue.Close()
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+
+	for _, expected := range []uint64{1, 2} {
+		select {
+		case item := <-ch:
+			require.Equal(t, expected, item.sequence)
+		case <-time.After(time.Second):
+			t.Fatal("queue is not flushed after the interval")
+		}
+	}
+	require.Equal(t, 0, queue.Len())
+}
+
+func TestPeek(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+
+	_, ok := queue.Peek()
+	require.False(t, ok)
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+	item, ok := queue.Peek()
+	require.True(t, ok)
+	require.Equal(t, testEvent{sequence: 1}, item)
+	require.Equal(t, 2, queue.Len())
+}
+
+func TestClear(t *testing.T) {
+	ch := make(chan testEvent, 100)
+	queue := NewEventQueue[testEvent](100, ch, sequenceComparator)
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+	queue.Clear()
+	require.Equal(t, 0, queue.Len())
+	require.Equal(t, defaultCapacity, cap(queue.queue.data))
+
+	for i := 0; i < 50; i++ {
+		queue.Push(testEvent{sequence: uint64(i)})
+	}
+	queue.Clear()
+	require.Equal(t, 0, queue.Len())
+	require.Equal(t, defaultCapacity, cap(queue.queue.data))
+
+	queue.Flush()
+	require.Len(t, ch, 0)
+}
+
+func TestPushAll(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator)
+	queue.Push(testEvent{sequence: 4})
+
+	queue.PushAll([]testEvent{{sequence: 5}, {sequence: 2}, {sequence: 3}, {sequence: 1}})
+	require.Equal(t, 2, queue.Len())
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Len(t, ch, 0)
+
+	queue.PushAll([]testEvent{{sequence: 6}})
+	require.Equal(t, testEvent{sequence: 4}, <-ch)
+	require.Equal(t, []testEvent{{sequence: 5}, {sequence: 6}}, queue.Drain())
+}
+
+func benchmarkPush(b *testing.B, push func(queue *EventQueue[int], items []int)) {
+	items := make([]int, 1000)
+	for i := range items {
+		items[i] = (i * 7919) % len(items)
+	}
+	ch := make(chan int, len(items))
+	less := ComparatorFunc[int](func(a, b int) bool { return a < b })
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		queue := NewEventQueue[int](len(items)+1, ch, less)
+		push(queue, items)
+	}
+}
+
+func BenchmarkPush(b *testing.B) {
+	benchmarkPush(b, func(queue *EventQueue[int], items []int) {
+		for _, item := range items {
+			queue.Push(item)
+		}
+	})
+}
+
+func BenchmarkPushAll(b *testing.B) {
+	benchmarkPush(b, func(queue *EventQueue[int], items []int) {
+		queue.PushAll(items)
+	})
+}
+
+// BenchmarkInputOrder pushes events through a queue in different orders,
+// cmp/event is the number of comparisons per event
+func BenchmarkInputOrder(b *testing.B) {
+	sorted, reverse, random := make([]int, 1000), make([]int, 1000), make([]int, 1000)
+	for i := range sorted {
+		sorted[i] = i
+		reverse[i] = len(reverse) - i
+		random[i] = (i * 7919) % len(random)
+	}
+	b.Run("sorted", func(b *testing.B) { benchmarkInputOrder(b, sorted) })
+	b.Run("reverse", func(b *testing.B) { benchmarkInputOrder(b, reverse) })
+	b.Run("random", func(b *testing.B) { benchmarkInputOrder(b, random) })
+}
+
+func benchmarkInputOrder(b *testing.B, items []int) {
+	ch := make(chan int, len(items))
+	comparisons := 0
+	less := ComparatorFunc[int](func(a, b int) bool {
+		comparisons++
+		return a < b
+	})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		queue := NewEventQueue[int](100, ch, less)
+		for _, item := range items {
+			queue.Push(item)
+		}
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+	b.ReportMetric(float64(comparisons)/float64(b.N*len(items)), "cmp/event")
+}
+
+func TestInOrder(t *testing.T) {
+	queue := NewEventQueue[int](10, nil, ComparatorFunc[int](func(a, b int) bool { return a < b }))
+	for i := 1; i <= 5; i++ {
+		queue.Push(i)
+	}
+	require.Equal(t, []int{1, 2}, queue.PopN(2))
+	min, max, ok := queue.Bounds()
+	require.True(t, ok)
+	require.Equal(t, []int{3, 5}, []int{min, max})
+
+	// an event out of order falls back to sifting
+	queue.Push(0)
+	queue.Push(6)
+	min, max, _ = queue.Bounds()
+	require.Equal(t, []int{0, 6}, []int{min, max})
+	require.Equal(t, []int{0, 3, 4, 5, 6}, queue.Drain())
+
+	// an empty queue is in order again
+	queue.Push(2)
+	queue.Push(1)
+	require.Equal(t, []int{1, 2}, queue.Drain())
+}
+
+func TestContains(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	queue.Push(testEvent{sequence: 1, content: 10})
+	queue.Push(testEvent{sequence: 2, content: 20})
+
+	require.True(t, queue.Contains(func(item testEvent) bool { return item.content == 20 }))
+	require.False(t, queue.Contains(func(item testEvent) bool { return item.content == 30 }))
+	require.False(t, queue.ContainsKey("1"), "no key index without WithDedup")
+}
+
+func TestRemove(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	for _, sequence := range []uint64{5, 3, 1, 4, 2} {
+		queue.Push(testEvent{sequence: sequence, content: int(sequence % 2)})
+	}
+
+	odd := func(item testEvent) bool { return item.content == 1 }
+	item, ok := queue.Remove(odd)
+	require.True(t, ok)
+	require.Equal(t, testEvent{sequence: 1, content: 1}, item)
+	item, ok = queue.Remove(func(item testEvent) bool { return item.sequence == 4 })
+	require.True(t, ok)
+	require.Equal(t, uint64(4), item.sequence)
+	_, ok = queue.Remove(func(item testEvent) bool { return item.sequence == 4 })
+	require.False(t, ok)
+
+	require.Equal(t, 3, queue.Len())
+	require.Equal(t, []testEvent{{sequence: 2}, {sequence: 3, content: 1}, {sequence: 5, content: 1}}, queue.Drain())
+}
+
+func TestBackgroundEmitter(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator, WithBackgroundEmitter[testEvent](), WithOwnedOutput[testEvent]())
+
+	// nobody reads the channel, but Push does not block
+	for _, sequence := range []uint64{3, 1, 2, 5, 4} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	go queue.Close()
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.sequence)
+	}
+	require.Equal(t, []uint64{1, 2, 3, 4, 5}, emitted)
+}
+
+func TestUpdate(t *testing.T) {
+	ch := make(chan *testEvent, 10)
+	queue := NewEventQueue[*testEvent](10, ch, ComparatorFunc[*testEvent](func(a, b *testEvent) bool {
+		return a.sequence < b.sequence
+	}))
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(&testEvent{sequence: sequence, content: int(sequence)})
+	}
+
+	require.True(t, queue.Update(func(item *testEvent) bool { return item.content == 1 }, func(item *testEvent) *testEvent {
+		item.sequence = 10
+		return item
+	}))
+	require.False(t, queue.Update(func(item *testEvent) bool { return item.content == 5 }, func(item *testEvent) *testEvent { return item }))
+
+	var contents []int
+	for _, item := range queue.Drain() {
+		contents = append(contents, item.content)
+	}
+	require.Equal(t, []int{2, 3, 1}, contents)
+}
+
+func TestCompareAndPushIndex(t *testing.T) {
+	queue := NewEventQueue[testEvent](1000, nil, sequenceComparator)
+	key := func(item testEvent) string { return strconv.Itoa(item.content) }
+	newer := func(existing, incoming testEvent) bool { return incoming.sequence < existing.sequence }
+	for i := 0; i < 500; i++ {
+		queue.CompareAndPush(testEvent{sequence: uint64((i * 7919) % 1000), content: i % 50}, key, newer)
+		if i%7 == 0 {
+			queue.PopN(1)
+		}
+		if i%11 == 0 {
+			queue.Remove(func(item testEvent) bool { return item.content == i%50 })
+		}
+	}
+
+	queue.lock.Lock()
+	require.Len(t, queue.queue.index, queue.queue.Len())
+	for i, item := range queue.queue.data {
+		require.Equal(t, i, queue.queue.index[key(item)])
+	}
+	queue.lock.Unlock()
+	require.Equal(t, queue.Len(), len(queue.Snapshot()))
+}
+
+// BenchmarkEmit pushes events through a queue in steady state,
+// allocs/op is the number of allocations per event
+func BenchmarkEmit(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkEmit(b) })
+	b.Run("reuse", func(b *testing.B) { benchmarkEmit(b, WithBufferReuse[testEvent]()) })
+}
+
+func benchmarkEmit(b *testing.B, options ...Option[testEvent]) {
+	ch := make(chan testEvent, 1000)
+	go func() {
+		for range ch {
+		}
+	}()
+	queue := NewEventQueue[testEvent](100, ch, sequenceComparator, options...)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		queue.Push(testEvent{sequence: uint64((i * 7919) % 1000)})
+	}
+	queue.Close()
+	close(ch)
+}
+
+func TestDrainOrder(t *testing.T) {
+	ch := make(chan testEvent, 100)
+	queue := NewEventQueue[testEvent](50, ch, sequenceComparator, WithEmitAll[testEvent]())
+	for i := 0; i < 80; i++ {
+		queue.Push(testEvent{sequence: uint64((i * 37) % 80)})
+	}
+	// the events drained on the threshold trigger are delivered in ascending order
+	require.Len(t, ch, 50)
+	previous := <-ch
+	for i := 1; i < 50; i++ {
+		item := <-ch
+		require.LessOrEqual(t, previous.sequence, item.sequence)
+		previous = item
+	}
+
+	queue.Flush()
+	require.Len(t, ch, 30)
+	previous = <-ch
+	for i := 1; i < 30; i++ {
+		item := <-ch
+		require.LessOrEqual(t, previous.sequence, item.sequence)
+		previous = item
+	}
+}
@@ -0,0 +1,39 @@
+package generic
+
+import "encoding/json"
+
+// MarshalJSON encodes the buffered events as a JSON array in sorted order, see Snapshot
+func (es *EventQueue[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(es.Snapshot())
+}
+
+// UnmarshalJSON replaces the buffered events with the ones decoded from a JSON array,
+// see Restore. The queue must be created by a constructor beforehand.
+// If T is an interface type, the events can not be decoded generically, see RestoreJSON
+func (es *EventQueue[T]) UnmarshalJSON(data []byte) error {
+	var items []T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	return es.Restore(items)
+}
+
+// RestoreJSON replaces the buffered events with the ones decoded from a JSON array,
+// decoding every element with decode. It helps when T is an interface type,
+// so that json.Unmarshal does not know the concrete type of the events
+func (es *EventQueue[T]) RestoreJSON(data []byte, decode func(json.RawMessage) (T, error)) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	items := make([]T, len(raw))
+	for i, message := range raw {
+		item, err := decode(message)
+		if err != nil {
+			return err
+		}
+		items[i] = item
+	}
+	return es.Restore(items)
+}
@@ -0,0 +1,39 @@
+package generic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type jsonEvent struct {
+	Sequence uint64 `json:"sequence"`
+}
+
+func TestJSON(t *testing.T) {
+	bySequence := ComparatorFunc[jsonEvent](func(a, b jsonEvent) bool { return a.Sequence < b.Sequence })
+	ch := make(chan jsonEvent, 10)
+	queue := NewEventQueue[jsonEvent](10, ch, bySequence)
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(jsonEvent{Sequence: sequence})
+	}
+
+	data, err := json.Marshal(queue)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"sequence":1},{"sequence":2},{"sequence":3}]`, string(data))
+
+	restored := NewEventQueue[jsonEvent](10, ch, bySequence)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.Equal(t, queue.Snapshot(), restored.Snapshot())
+
+	untyped := NewEventQueue[interface{}](10, make(chan interface{}), ComparatorFunc[interface{}](func(a, b interface{}) bool {
+		return a.(jsonEvent).Sequence < b.(jsonEvent).Sequence
+	}))
+	require.NoError(t, untyped.RestoreJSON(data, func(message json.RawMessage) (interface{}, error) {
+		var event jsonEvent
+		err := json.Unmarshal(message, &event)
+		return event, err
+	}))
+	require.Equal(t, []interface{}{jsonEvent{Sequence: 1}, jsonEvent{Sequence: 2}, jsonEvent{Sequence: 3}}, untyped.Drain())
+}
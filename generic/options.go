@@ -0,0 +1,652 @@
+package generic
+
+import "time"
+
+// Option configures optional behavior of EventQueue, see NewEventQueue
+type Option[T any] func(*EventQueue[T])
+
+// WithInitialCapacity preallocates the buffer of the queue for initialCapacity events,
+// which saves reallocations for a large emitThreshold. Use emitThreshold or more.
+// Negative values are ignored, so the default capacity of 10 events is used
+func WithInitialCapacity[T any](initialCapacity int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if initialCapacity >= 0 {
+			es.initialCapacity = initialCapacity
+		}
+	}
+}
+
+// WithBufferReuse reduces garbage under high throughput: Clear and Restore keep
+// the capacity of the buffer instead of shrinking it back to the initial capacity,
+// and the slice of events sent at once is reused for the next ones, unless
+// the queue is created by NewBatchEventQueue, which hands the slices to the consumer
+func WithBufferReuse[T any]() Option[T] {
+	return func(es *EventQueue[T]) {
+		es.reuse = true
+	}
+}
+
+// WithOwnedOutput transfers ownership of the output channel to EventQueue:
+// the channel is closed by Close once all the events are emitted.
+// The client must not close the channel itself then
+func WithOwnedOutput[T any]() Option[T] {
+	return func(es *EventQueue[T]) { es.ownsOutput = true }
+}
+
+// WithAdaptiveThreshold makes the queue tune emitThreshold to the arrival rate
+// within [min, max]: it grows when events arrive in bursts, to sort more of them at once,
+// and shrinks when the stream is calm, so events are not held for long. The queue keeps
+// short-term and long-term moving averages of the time between arrivals, emitThreshold
+// is scaled by their ratio, so at a steady rate it stays as the queue is created with
+// or as set by SetEmitThreshold. The current value is reported in Stats.
+// The option is ignored unless 0 < min <= max
+func WithAdaptiveThreshold[T any](min, max int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if min > 0 && min <= max {
+			es.adaptive = &adaptive{min: min, max: max}
+		}
+	}
+}
+
+// WithFlushComparator makes the queue sort the events flushed at once, i.e. by Flush,
+// FlushContext, WithFlushInterval and the drain of Close, by comparator instead of
+// the comparator of the queue, e.g. to emit the stream by timestamp but group the rest
+// by key at the end. The events taken for emission but not sent yet are sorted with them.
+// WithReverse does not apply to comparator
+func WithFlushComparator[T any](comparator Comparator[T]) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.flushComparator = comparator
+	}
+}
+
+// WithCloseSentinel makes Close send sentinel to output once all the events are emitted,
+// before output is closed with WithOwnedOutput, so consumers can tell the end of the stream
+// from an abrupt stop. The sentinel is sent once, it is neither counted in Stats
+// nor reported to the OnEmit hooks. NewBatchEventQueue sends it as a batch of its own.
+// Pull-only queues send nothing
+func WithCloseSentinel[T any](sentinel T) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.sentinel, es.hasSentinel = sentinel, true
+	}
+}
+
+// WithRouter makes the queue send each emitted event to the channel picked by route,
+// e.g. by tenant, so the queue works as an ordered demultiplexer. The events route
+// returns nil for go to output as usual. The events are sent in order to each channel,
+// but a slow consumer of one channel holds back the others. route is called by
+// the emitting goroutine, an event that is not delivered, e.g. when PushContext
+// is canceled, is routed again later, so route must pick the same channel for it.
+// Close and WithOwnedOutput close output only, the routed channels belong to the client.
+// Pull-only queues (see NewEventQueue) do not route events until AddOutput gives them an output
+func WithRouter[T any](route func(item T) chan<- T) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.router = route
+	}
+}
+
+// WithLowWatermark makes the queue drain down to lowWatermark events each time
+// emitThreshold is hit, instead of emitting a single event. The drained events
+// are emitted in sorted order. Negative values and values that are not below
+// emitThreshold are ignored, so the queue emits a single event per Push as by default
+func WithLowWatermark[T any](lowWatermark int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if lowWatermark >= 0 {
+			es.lowWatermark = lowWatermark
+		}
+	}
+}
+
+// WithEmitAll makes the queue emit all the buffered events in sorted order each time
+// emitThreshold is hit, leaving the queue empty, so that every window of emitThreshold
+// events is processed at once. It is the same as WithLowWatermark(0)
+func WithEmitAll[T any]() Option[T] {
+	return WithLowWatermark[T](0)
+}
+
+// WithWindowMode makes emission strictly window-aligned: the queue accumulates
+// emitThreshold events, emits the whole window in sorted order and starts a fresh one.
+// Unlike WithEmitAll it never emits a partial window, if the queue holds more than
+// a window the rest stays for the next one. Partial windows are emitted only
+// by Flush and Close, or by WithMinHold and WithHoldBounds
+func WithWindowMode[T any](window bool) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.window = window
+	}
+}
+
+// WithImmediate emits urgent events without waiting for emitThreshold: each pushed event
+// for which urgent returns true makes the push emit one event at least, whatever the queue
+// length is. The smallest buffered event goes, which is not necessarily the urgent one,
+// so the order is kept. A sequence gap of WithSequence still holds the events back, and
+// nothing is emitted while the queue is paused or in manual mode. urgent is called with
+// the queue lock held, so it must not call the queue
+func WithImmediate[T any](urgent func(item T) bool) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.immediate = urgent
+	}
+}
+
+// WithManualEmit turns off automatic emission, so the queue is a plain thread-safe
+// priority queue: pushes only buffer the events, reaching emitThreshold, WithMinHold
+// and WithFlushInterval emit nothing. The events are taken with PopN, Drain or Next,
+// or sent to the output with EmitNow, Flush and Close. Unlike Pause, Resume does not
+// turn it off
+func WithManualEmit[T any](manual bool) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.manual = manual
+	}
+}
+
+// WithFlushInterval makes the queue flush itself if no event is emitted within
+// flushInterval, so events do not get stuck below emitThreshold when the stream stalls.
+// The interval starts over on each emission. The timer is stopped by Close.
+// Non-positive values are ignored
+func WithFlushInterval[T any](flushInterval time.Duration) Option[T] {
+	return func(es *EventQueue[T]) {
+		if flushInterval > 0 {
+			es.flushInterval = flushInterval
+		}
+	}
+}
+
+// WithHeartbeat keeps the consumer aware that the queue is alive during quiet periods:
+// once no event is emitted for interval, the queue sends a beat made by makeBeat to its
+// output, and so on every interval until an event is emitted. The beats are not buffered,
+// they go between the emissions without changing the order of the events, and are not
+// counted in Stats. A beat is skipped if the output is not ready to take it right away.
+// makeBeat is called with the queue lock held, so it must not call the queue.
+// Non-positive interval is ignored
+func WithHeartbeat[T any](interval time.Duration, makeBeat func() T) Option[T] {
+	return func(es *EventQueue[T]) {
+		if interval > 0 {
+			es.heartbeatInterval = interval
+			es.makeBeat = makeBeat
+		}
+	}
+}
+
+// WithCoalesceWindow makes the queue send the events due for emission at the end
+// of fixed windows of window length, aligned to the clock, instead of right away:
+// the events that get due within a window go at once, as one sorted batch with
+// NewBatchEventQueue, which smooths the load of the consumer. The due events stay buffered
+// until the window ends, so Len, Snapshot, Clear and Drain see them, and an event pushed
+// meanwhile that sorts before them goes in their place. Flush, Close and the calls that
+// report delivery, i.e. TryPush and PushContext, send the events right away.
+// The timer is stopped by Close. Non-positive values are ignored
+func WithCoalesceWindow[T any](window time.Duration) Option[T] {
+	return func(es *EventQueue[T]) {
+		if window > 0 {
+			es.coalesceWindow = window
+		}
+	}
+}
+
+// WithSequence gates emission on contiguity of event sequence numbers: the queue emits
+// only the contiguous prefix starting at the next expected sequence, which is first
+// initially, and holds back the events beyond a gap until the gap is filled.
+// Once it is, the queue drains down to emitThreshold-1 events (or to lowWatermark).
+// Late events, that are behind the next expected sequence, are not held back.
+// Flush and Close emit everything regardless of gaps; events pulled out with PopN
+// count as emitted too
+func WithSequence[T any](sequence SequenceFunc[T], first uint64) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.sequence = sequence
+		es.nextSequence = first
+	}
+}
+
+// WithDedup drops duplicate events: an event is ignored if an event with the same key,
+// as returned by keyFunc, is buffered at the moment. Once an event leaves the queue
+// its key may be pushed again. Push, PushErr, PushContext and TryPush ignore duplicates
+// silently, PushDedup reports whether the event is accepted
+func WithDedup[T any](keyFunc func(T) string) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.dedup = keyFunc
+		es.keys = make(map[string]struct{})
+	}
+}
+
+// WithDedupTTL drops duplicate events within a time window: an event is ignored if an event
+// with the same key, as returned by keyFunc, is pushed less than ttl ago, whether it is still
+// buffered or emitted already. The window starts at the first accepted event of the key,
+// the ignored duplicates do not extend it. The expired keys are evicted lazily on push.
+// It may be combined with WithDedup, an event is ignored if either of them finds it duplicate
+func WithDedupTTL[T any](keyFunc func(T) string, ttl time.Duration) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.seenKey = keyFunc
+		es.dedupTTL = ttl
+		es.seen = make(map[string]struct{})
+	}
+}
+
+// WithDuplicateSequence detects pushed events with the same sequence number, as returned
+// by sequence, as an event buffered at the moment, which usually means a bug upstream.
+// Unlike WithDedup it is meant for reporting: a duplicate is passed to onDuplicate,
+// if it is not nil, once the lock is released and counted in Stats.Duplicates.
+// If drop is true the duplicate is ignored like with WithDedup, otherwise it is added
+func WithDuplicateSequence[T any](sequence SequenceFunc[T], onDuplicate func(item T), drop bool) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.duplicateSequence = sequence
+		es.sequences = make(map[uint64]int)
+		es.onDuplicate = onDuplicate
+		es.dropDuplicates = drop
+	}
+}
+
+// WithMinHold makes the queue emit an event once it has been held for minHold
+// since its own timestamp, even if the queue is below emitThreshold. So an event
+// is emitted when either emitThreshold is reached or its hold time is over,
+// which is a reorder buffer with a fixed latency budget.
+// Only the smallest event is checked, so timestamp should agree with the comparator,
+// e.g. when events are sorted by the same timestamp.
+// Non-positive minHold is ignored
+func WithMinHold[T any](minHold time.Duration, timestamp func(T) time.Time) Option[T] {
+	return func(es *EventQueue[T]) {
+		if minHold > 0 {
+			es.releaseAfter = minHold
+			es.timestamp = timestamp
+		}
+	}
+}
+
+// WithHoldBounds bounds how long an event stays in the queue, counting from its own
+// timestamp: it is never emitted by reaching emitThreshold before minHold, and it is
+// always emitted once maxHold is over, even if the queue is below emitThreshold.
+// This gives predictable latency bounds for a reorder buffer. Flush and Close do not
+// wait for minHold. Like WithMinHold, only the smallest event is checked.
+// Non-positive durations disable the corresponding bound, and maxHold below minHold
+// is raised to minHold
+func WithHoldBounds[T any](minHold, maxHold time.Duration, timestamp func(T) time.Time) Option[T] {
+	return func(es *EventQueue[T]) {
+		if maxHold > 0 && maxHold < minHold {
+			maxHold = minHold
+		}
+		if minHold > 0 || maxHold > 0 {
+			es.holdFloor = minHold
+			es.releaseAfter = maxHold
+			es.timestamp = timestamp
+		}
+	}
+}
+
+// WithStableOrder makes events that are equal by the comparator leave the queue
+// in the order they were pushed, e.g. events with the same timestamp go FIFO.
+// The insertion order is kept next to every buffered event, which costs
+// 8 bytes per event
+func WithStableOrder[T any]() Option[T] {
+	return func(es *EventQueue[T]) {
+		es.queue.stable = true
+	}
+}
+
+// WithComparatorRecovery makes the queue survive a comparator that panics, e.g. on
+// a bad type assertion. The panic is recovered, the comparison is treated as "not less"
+// and onPanic, if not nil, receives an error wrapping ErrComparatorPanic. The queue
+// stays usable, though the order of the events involved is not guaranteed.
+// onPanic is called with the queue lock held, so it must not call the queue
+func WithComparatorRecovery[T any](onPanic func(err error)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.recoverComparator = true
+		es.onComparatorPanic = onPanic
+	}
+}
+
+// WithAging makes events that wait in the queue gain priority, so a stream of
+// high priority events can't starve the low priority ones. aging returns the
+// effective priority of item that has waited for waited since it was pushed,
+// the smaller the sooner it is emitted. Events with the same effective priority
+// are ordered by the comparator.
+// Priorities change over time, so the heap is rebuilt before each emitted event,
+// which costs O(n) instead of O(log n). Events returned to the queue after a
+// failed send start waiting anew
+func WithAging[T any](aging func(item T, waited time.Duration) int) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.queue.aging = aging
+	}
+}
+
+// WithReverse makes the queue emit events in descending order, so the comparator
+// can stay written as "a < b" while the largest events go out first
+func WithReverse[T any](reverse bool) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.reverse = reverse
+	}
+}
+
+// WithOnPush sets a hook called after an event is added to the queue.
+// The hook runs outside the lock of the queue, so it may be called after the event
+// is already emitted. A slow hook slows down the pushing goroutine, and the hook
+// must not call back into the queue
+func WithOnPush[T any](hook func(T)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.onPush = hook
+	}
+}
+
+// WithOnEmit sets a hook called just before an event is sent to output channel.
+// The hook runs outside the lock of the queue, by the goroutine sending the events.
+// If the send is canceled (see PushContext) the event stays in the queue and is
+// reported again when it is emitted later. A slow hook slows down the emission,
+// and the hook must not call back into the queue
+func WithOnEmit[T any](hook func(T)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.onEmit = hook
+	}
+}
+
+// WithExpiry drops the events whose deadline has passed by the time they are taken
+// for emission, so with a comparator ordering events by deadline the queue works as
+// a timeout wheel. The expired events are not sent to output channel, they are passed
+// to onExpired, if it is not nil, once the lock is released and counted in Stats.Expired
+func WithExpiry[T any](deadline func(item T) time.Time, onExpired func(item T)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.deadline = deadline
+		es.onExpired = onExpired
+	}
+}
+
+// WithOnEmitBatch sets a hook called once per batch of events sent at once, e.g.
+// drained on a threshold trigger or by Flush, just before the batch is sent to output
+// channel. The hook gets the events in emission order and runs like the hook of
+// WithOnEmit does. The slice is valid only during the call, the hook must not keep it
+func WithOnEmitBatch[T any](hook func([]T)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.onEmitBatch = hook
+	}
+}
+
+// WithPacingChannel makes the queue send the events due for emission one per signal
+// of ready, or one batch per signal with NewBatchEventQueue, so the consumer hands out
+// credits at its own pace. emitThreshold still tells when the events get due, and the
+// events waiting for a signal stay buffered in order. The emitting goroutine waits for
+// signals with the queue lock released. Close drains the queue without waiting for them.
+// TryPush gives up if there is no signal right away. A nil channel is ignored
+func WithPacingChannel[T any](ready <-chan struct{}) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.ready = ready
+	}
+}
+
+// WithEmitRate limits emission to eventsPerSecond events per second, allowing bursts
+// of up to burst events. The events that can not be emitted yet stay buffered in order,
+// and the emitting goroutine waits for the limiter with the queue lock released,
+// so pushes do not wait for it unless they emit themselves.
+// Close drains the queue at the same rate. TryPush gives up if the limiter does not
+// let the events through right away. Non-positive eventsPerSecond is ignored
+func WithEmitRate[T any](eventsPerSecond float64, burst int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if eventsPerSecond > 0 {
+			es.emitRate = eventsPerSecond
+			es.emitBurst = burst
+		}
+	}
+}
+
+// WithBackgroundEmitter makes a dedicated goroutine send the events emitted by Push
+// and PushAll, so they only buffer the events and never send to output channel
+// themselves. Other methods such as PushContext and Flush still send on their own,
+// in the same order. Close drains the queue and stops the goroutine.
+// Note that a panic while sending, e.g. if the client closes the channel,
+// happens in that goroutine and crashes the program
+func WithBackgroundEmitter[T any]() Option[T] {
+	return func(es *EventQueue[T]) {
+		es.wake = make(chan struct{}, 1)
+	}
+}
+
+// WithShards makes concurrent pushes scale: Push, PushErr and PushDedup add events
+// to one of n shards in turn, each with its own lock, instead of taking the queue lock.
+// The shards are unsorted buffers, not heaps: once they hold n events altogether,
+// they are merged into the heap of the queue, which emits the due events in global order.
+// So emission lags behind by up to n-1 events: an event that is due on push waits
+// in its shard until the pushes fill the shards, e.g. with emitThreshold 1 and 4 shards
+// nothing is emitted before the 4th push. Flush and the other methods merge the shards
+// first, so they see all the pushed events.
+// The shards are not used with the options that check events on push: WithDedup,
+// WithDuplicateSequence, WithMonotonicEmission, WithReorderStats, WithMaxSize,
+// WithMaxBytes, WithBlockingBounds, WithMinHold and WithHoldBounds.
+// Values below 2 are ignored
+func WithShards[T any](n int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if n >= 2 {
+			es.shards = make([]shard[T], n)
+		}
+	}
+}
+
+// WithWatermark sends watermarks to watermarkChannel alongside the emitted events:
+// a watermark X tells that all the events pushed so far with sequences up to X, as
+// returned by sequence, are delivered. It is taken after each send as the sequence right
+// before the smallest undelivered event, or the largest delivered sequence once the queue
+// is empty, so sequence is expected to follow the order of the comparator. The watermarks
+// are monotonic, a late event pushed with a smaller sequence does not move them back.
+// They are sent by a dedicated goroutine, the consumer that falls behind gets the latest
+// watermark only. watermarkChannel is not closed by Close, a watermark it does not take
+// by then is given up, so that the goroutine does not outlive the queue
+func WithWatermark[T any](watermarkChannel chan<- uint64, sequence SequenceFunc[T]) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.watermarks = &watermarks[T]{
+			ch:       watermarkChannel,
+			sequence: sequence,
+			wake:     make(chan struct{}, 1),
+			done:     make(chan struct{}),
+		}
+	}
+}
+
+// WithBackpressure signals the producers that the queue grows too long, so that they
+// can slow down. onHigh is called once the queue holds more than softLimit events,
+// then onLow is called once it gets down to softLimit/2 events, and so on. Both get
+// the queue length at the moment of the change. The callbacks are called in order by
+// a dedicated goroutine, without the queue lock held, so they may lag behind the queue.
+// Either callback may be nil. Non-positive softLimit is ignored
+func WithBackpressure[T any](softLimit int, onHigh, onLow func(curLen int)) Option[T] {
+	return func(es *EventQueue[T]) {
+		if softLimit > 0 {
+			es.pressure = &backpressure{
+				softLimit: softLimit,
+				release:   softLimit / 2,
+				onHigh:    onHigh,
+				onLow:     onLow,
+				wake:      make(chan struct{}, 1),
+			}
+		}
+	}
+}
+
+// WithReorderStats makes the queue measure how far out of order the events arrive,
+// see ReorderMax, ReorderAvg and Late in Stats. It helps to size emitThreshold.
+// Measuring scans the queue on every push, so it costs O(n) per push
+func WithReorderStats[T any]() Option[T] {
+	return func(es *EventQueue[T]) {
+		es.reorder = true
+	}
+}
+
+// WithOnDrop sets a hook called for every event the queue discards: the events
+// dropped by the overflow policy of WithMaxSize and the events discarded by Clear
+// or replaced by Restore, and the events given up by WithSendTimeout.
+// The reason tells them apart. The hook runs outside the lock
+// of the queue, and it must not call back into the queue
+func WithOnDrop[T any](hook func(item T, reason DropReason)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.onDrop = hook
+	}
+}
+
+// DropReason tells why an event is discarded, see WithOnDrop
+type DropReason int
+
+const (
+	// DropOverflow means the event is dropped because the queue is full, see WithMaxSize
+	DropOverflow DropReason = iota
+	// DropCleared means the event is discarded by Clear or Restore
+	DropCleared
+	// DropTimeout means the consumer did not take the event in time, see WithSendTimeout
+	DropTimeout
+	// DropLate means the event sorts before an event emitted already, see WithMonotonicEmission
+	DropLate
+)
+
+type droppedEvent[T any] struct {
+	item   T
+	reason DropReason
+}
+
+// ErrorPolicy tells what a queue created by NewEventQueueErrFunc does when its sink fails
+type ErrorPolicy int
+
+const (
+	// RetryOnError puts the failed event back to the queue, so it goes first
+	// at the next emission, e.g. on the next Push or Flush
+	RetryOnError ErrorPolicy = iota
+	// DropOnError drops the failed event and goes on with the next ones.
+	// The dropped event is counted as emitted in Stats
+	DropOnError
+	// StopOnError stops emission for good, see Err. The failed event and the events
+	// pushed afterwards stay in the queue, so they can be taken with Drain
+	StopOnError
+)
+
+// WithErrorPolicy sets what happens when the sink of NewEventQueueErrFunc fails.
+// onError, if not nil, is called with every error, by the emitting goroutine
+// with the queue lock released. Other queues ignore the option
+func WithErrorPolicy[T any](policy ErrorPolicy, onError func(item T, err error)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.errorPolicy = policy
+		es.onError = onError
+	}
+}
+
+// WithSendTimeout makes the queue give up on an event that output channel does not
+// take within timeout, so that a stuck consumer does not freeze emission for good.
+// If drop is set, the event is dropped, counted in Stats and reported to the OnDrop
+// hook, and the next events go on. Otherwise the event and the ones after it stay
+// in the queue in order until the next emission. The timeout applies to channel outputs
+// only, the timer is reused for all the sends. Non-positive timeout is ignored
+func WithSendTimeout[T any](timeout time.Duration, drop bool) Option[T] {
+	return func(es *EventQueue[T]) {
+		if timeout > 0 {
+			es.sendTimeout = timeout
+			es.dropTimedOut = drop
+		}
+	}
+}
+
+// OverflowPolicy tells what a bounded queue does when it is full, see WithMaxSize
+type OverflowPolicy int
+
+const (
+	// DropNewest rejects the incoming event
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the smallest buffered event to make room for the incoming one
+	DropOldest
+	// Block makes the push wait until some event leaves the queue
+	Block
+)
+
+// WithBlockingBounds gives producers flow control: once high events are undelivered,
+// i.e. buffered or being sent to a slow consumer, Push and PushContext block until
+// emission brings them down to low. TryPush drops the event with ErrFull instead.
+// Flush, Drain and PopN unblock the pushes as well, Close makes them return ErrClosed.
+// Note that the events held below emitThreshold are emitted only by further pushes,
+// so with low below emitThreshold-1 the pushes may block until Flush.
+// low is capped to high-1, non-positive high values are ignored
+func WithBlockingBounds[T any](high, low int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if high <= 0 {
+			return
+		}
+		if low >= high {
+			low = high - 1
+		}
+		es.blockHigh, es.blockLow = high, low
+	}
+}
+
+// WithMonotonicEmission makes the output stream strictly ordered: an event that sorts
+// before the last emitted event is late, it is rejected instead of being emitted out
+// of order. Late events are diverted to onLate, or dropped if onLate is nil,
+// see WithOnDrop. onLate is called once the lock is released.
+// PushErr and PushContext return ErrLate for late events, TryPush returns false.
+// Late events are counted in Stats.Late
+func WithMonotonicEmission[T any](onLate func(item T)) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.monotonic = true
+		es.onLate = onLate
+	}
+}
+
+// WithSizer makes the queue keep the total size of the buffered events, see SizeBytes.
+// sizer returns the estimated size of an event in bytes, it is called with the queue
+// lock held once the event is added and once it leaves the queue, so it must return
+// the same value for the same event
+func WithSizer[T any](sizer func(item T) int) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.sizer = sizer
+	}
+}
+
+// WithWeight makes emitThreshold apply to the total weight of the buffered events
+// instead of their count, e.g. when events stand for different amounts of work.
+// Once the events weigh emitThreshold or more, the smallest ones are emitted until
+// the weight gets below it again, see Weight. weigh is called with the queue lock held
+// once the event is added and once it leaves the queue, so it must return the same
+// value for the same event. The option takes over WithWindowMode and WithLowWatermark
+func WithWeight[T any](weigh func(item T) int) Option[T] {
+	return func(es *EventQueue[T]) {
+		es.weigher = weigh
+	}
+}
+
+// WithDiskSpill bounds the heap to about maxMem events: once it holds more of them,
+// the largest half is written to a temporary file in dir, os.TempDir if dir is empty,
+// and read back as the heap drains, so the queue takes bursts larger than the memory
+// and still emits all the events in order. Each spill writes a file of its own sorted
+// events, which is deleted once it is read back, so the events are read by merging
+// the files. The events are encoded with encoding/gob, so their fields must be exported,
+// and an interface T needs the concrete types registered with gob.Register.
+// The spilled events count in Len and Stats. The methods that look at all the buffered
+// events, like Snapshot, Clone, ForEach, Contains, CountIf, Remove, Update, CompareAndPush,
+// Bounds and SetComparator, read the spilled events back and spill them again, so they
+// cost a pass over the files, as does the OnDrop hook on Clear.
+// WithStableOrder does not keep equal events in order once they are spilled.
+// If writing fails, the events stay in memory, if reading fails, the rest of the file
+// is dropped, either way Err reports the error. Non-positive maxMem is ignored
+func WithDiskSpill[T any](dir string, maxMem int) Option[T] {
+	return func(es *EventQueue[T]) {
+		if maxMem > 0 {
+			es.spill = &spill[T]{dir: dir, maxMem: maxMem}
+		}
+	}
+}
+
+// WithMaxSize bounds the queue to maxSize buffered events. When the queue is full,
+// an incoming event is handled according to policy. Drops are reported with ErrFull
+// by PushErr and PushContext and counted in Stats. Note that Block policy may block
+// forever if the only goroutine that could drain the queue is the one pushing.
+// Non-positive values are ignored
+func WithMaxSize[T any](maxSize int, policy OverflowPolicy) Option[T] {
+	return func(es *EventQueue[T]) {
+		if maxSize > 0 {
+			es.maxSize = maxSize
+			es.overflow = policy
+		}
+	}
+}
+
+// WithMaxBytes bounds the total size of the buffered events to maxBytes as estimated
+// by the sizer of WithSizer, without which the option is ignored. When an incoming
+// event does not fit, it is handled according to policy like with WithMaxSize:
+// DropOldest drops as many smallest events as needed to make room. An event larger
+// than maxBytes never fits, so it is dropped under any policy. The policy is shared
+// with WithMaxSize, the one given last applies to both bounds.
+// Non-positive values are ignored
+func WithMaxBytes[T any](maxBytes int, policy OverflowPolicy) Option[T] {
+	return func(es *EventQueue[T]) {
+		if maxBytes > 0 {
+			es.maxBytes = maxBytes
+			es.overflow = policy
+		}
+	}
+}
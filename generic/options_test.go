@@ -0,0 +1,705 @@
+package generic
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialCapacity(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](100, ch, sequenceComparator, WithInitialCapacity[testEvent](100))
+	require.Equal(t, 100, cap(queue.queue.data))
+
+	queue = NewEventQueue[testEvent](100, ch, sequenceComparator, WithInitialCapacity[testEvent](0))
+	require.Equal(t, 0, cap(queue.queue.data))
+
+	queue = NewEventQueue[testEvent](100, ch, sequenceComparator, WithInitialCapacity[testEvent](-1))
+	require.Equal(t, defaultCapacity, cap(queue.queue.data))
+
+	queue = NewEventQueue[testEvent](100, ch, sequenceComparator)
+	require.Equal(t, defaultCapacity, cap(queue.queue.data))
+}
+
+func TestBufferReuse(t *testing.T) {
+	ch := make(chan testEvent, 100)
+	queue := NewEventQueue[testEvent](50, ch, sequenceComparator, WithBufferReuse[testEvent]())
+	for i := 0; i < 60; i++ {
+		queue.Push(testEvent{sequence: uint64(60 - i)})
+	}
+	require.Len(t, ch, 11)
+	capacity := cap(queue.queue.data)
+	require.Greater(t, capacity, defaultCapacity)
+	queue.Clear()
+	require.Equal(t, capacity, cap(queue.queue.data))
+	require.Zero(t, queue.Len())
+}
+
+func TestDedup(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	key := func(item testEvent) string { return strconv.FormatUint(item.sequence, 10) }
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator, WithDedup[testEvent](key))
+
+	require.True(t, queue.PushDedup(testEvent{sequence: 1, content: 1}))
+	require.True(t, queue.PushDedup(testEvent{sequence: 2}))
+	require.False(t, queue.PushDedup(testEvent{sequence: 1, content: 2}))
+	require.Equal(t, 2, queue.Len())
+
+	require.True(t, queue.PushDedup(testEvent{sequence: 3}))
+	require.Equal(t, testEvent{sequence: 1, content: 1}, <-ch)
+
+	// the key is released once the event is emitted
+	require.True(t, queue.PushDedup(testEvent{sequence: 1, content: 3}))
+	require.Equal(t, testEvent{sequence: 1, content: 3}, <-ch)
+
+	queue.Clear()
+	require.True(t, queue.PushDedup(testEvent{sequence: 2}))
+	require.True(t, queue.ContainsKey("2"))
+	require.False(t, queue.ContainsKey("1"))
+}
+
+func TestDedupTTL(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	key := func(item testEvent) string { return strconv.FormatUint(item.sequence, 10) }
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithDedupTTL[testEvent](key, 50*time.Millisecond))
+
+	require.True(t, queue.PushDedup(testEvent{sequence: 1, content: 1}))
+	require.Equal(t, testEvent{sequence: 1, content: 1}, <-ch)
+
+	// the key is kept after the event is emitted, until ttl passes
+	require.False(t, queue.PushDedup(testEvent{sequence: 1, content: 2}))
+	queue.PushAll([]testEvent{{sequence: 2}, {sequence: 2, content: 1}})
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	require.Zero(t, queue.Len())
+
+	time.Sleep(60 * time.Millisecond)
+	require.True(t, queue.PushDedup(testEvent{sequence: 1, content: 3}))
+	require.Equal(t, testEvent{sequence: 1, content: 3}, <-ch)
+	require.False(t, queue.PushDedup(testEvent{sequence: 1, content: 4}))
+}
+
+func TestImmediate(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	urgent := func(item testEvent) bool { return item.content < 0 }
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithImmediate[testEvent](urgent))
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 3})
+	require.Len(t, ch, 0)
+
+	// the smallest event goes, not the urgent one
+	queue.Push(testEvent{sequence: 4, content: -1})
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	queue.Push(testEvent{sequence: 1, content: -1})
+	require.Equal(t, testEvent{sequence: 1, content: -1}, <-ch)
+
+	queue.PushAll([]testEvent{{sequence: 5, content: -1}, {sequence: 6, content: -1}})
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Equal(t, testEvent{sequence: 4, content: -1}, <-ch)
+	require.Len(t, ch, 0)
+	require.Equal(t, 2, queue.Len())
+}
+
+func TestManualEmit(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithManualEmit[testEvent](true))
+
+	for _, sequence := range []uint64{3, 1, 2, 4} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Len(t, ch, 0)
+	require.False(t, queue.WillEmitOnNextPush())
+
+	require.True(t, queue.EmitNow())
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Equal(t, []testEvent{{sequence: 2}}, queue.PopN(1))
+
+	queue.Resume()
+	queue.Push(testEvent{sequence: 5})
+	require.Len(t, ch, 0, "Resume does not turn manual mode off")
+
+	queue.Flush()
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Equal(t, testEvent{sequence: 4}, <-ch)
+	require.Equal(t, testEvent{sequence: 5}, <-ch)
+}
+
+func TestMaxSize(t *testing.T) {
+	ch := make(chan testEvent, 10)
+
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithMaxSize[testEvent](2, DropNewest))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 2}))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 3}))
+	require.Equal(t, ErrFull, queue.PushErr(testEvent{sequence: 1}))
+	require.Equal(t, []testEvent{{sequence: 2}, {sequence: 3}}, queue.PopN(2))
+	require.Equal(t, uint64(1), queue.Stats().Dropped)
+
+	queue = NewEventQueue[testEvent](10, ch, sequenceComparator, WithMaxSize[testEvent](2, DropOldest))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 2}))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 3}))
+	require.Equal(t, ErrFull, queue.PushErr(testEvent{sequence: 1}))
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 3}}, queue.PopN(2))
+}
+
+func TestMaxBytes(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	sizer := WithSizer[testEvent](func(item testEvent) int { return item.content })
+
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, sizer, WithMaxBytes[testEvent](50, DropNewest))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 2, content: 20}))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 3, content: 20}))
+	require.Equal(t, ErrFull, queue.PushErr(testEvent{sequence: 1, content: 20}))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 4, content: 10}))
+	require.Equal(t, 50, queue.SizeBytes())
+
+	queue = NewEventQueue[testEvent](10, ch, sequenceComparator, sizer, WithMaxBytes[testEvent](50, DropOldest))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 2, content: 20}))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 3, content: 20}))
+	require.Equal(t, ErrFull, queue.PushErr(testEvent{sequence: 4, content: 40}))
+	require.Equal(t, 40, queue.SizeBytes())
+	require.Equal(t, ErrFull, queue.PushErr(testEvent{sequence: 5, content: 60}))
+	require.Equal(t, []testEvent{{sequence: 4, content: 40}}, queue.PopN(2))
+	require.Equal(t, uint64(3), queue.Stats().Dropped)
+
+	queue = NewEventQueue[testEvent](10, ch, sequenceComparator, sizer, WithMaxBytes[testEvent](50, Block))
+	require.NoError(t, queue.PushErr(testEvent{sequence: 2, content: 30}))
+	pushed := make(chan error, 1)
+	go func() { pushed <- queue.PushErr(testEvent{sequence: 3, content: 30}) }()
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue does not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	queue.PopN(1)
+	require.NoError(t, <-pushed)
+	require.Equal(t, 30, queue.SizeBytes())
+}
+
+func TestMaxSizeBlock(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithMaxSize[testEvent](1, Block))
+	queue.Push(testEvent{sequence: 1})
+
+	require.False(t, queue.TryPush(testEvent{sequence: 2}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, queue.PushContext(ctx, testEvent{sequence: 2}), context.DeadlineExceeded)
+
+	pushed := make(chan error, 1)
+	go func() { pushed <- queue.PushErr(testEvent{sequence: 3}) }()
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue does not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	require.Equal(t, []testEvent{{sequence: 1}}, queue.PopN(1))
+	require.NoError(t, <-pushed)
+	require.Equal(t, 1, queue.Len())
+
+	go func() { pushed <- queue.PushErr(testEvent{sequence: 4}) }()
+	time.Sleep(10 * time.Millisecond)
+	queue.Close()
+	require.Equal(t, ErrClosed, <-pushed)
+}
+
+func TestReverse(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator, WithReverse[testEvent](true), WithOwnedOutput[testEvent]())
+	for _, sequence := range []uint64{2, 5, 1, 4, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Close()
+
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.sequence)
+	}
+	require.Equal(t, []uint64{5, 4, 3, 2, 1}, emitted)
+}
+
+func TestHooks(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	var pushed, emitted []uint64
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator,
+		WithOnPush(func(item testEvent) { pushed = append(pushed, item.sequence) }),
+		WithOnEmit(func(item testEvent) { emitted = append(emitted, item.sequence) }),
+	)
+
+	queue.Push(testEvent{sequence: 2})
+	require.NoError(t, queue.PushContext(context.Background(), testEvent{sequence: 3}))
+	require.True(t, queue.TryPush(testEvent{sequence: 1}))
+	queue.Flush()
+
+	require.Equal(t, []uint64{2, 3, 1}, pushed)
+	require.Equal(t, []uint64{2, 1, 3}, emitted)
+	require.Len(t, ch, 3)
+}
+
+func TestOnEmitBatch(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	var batches [][]uint64
+	onEmitBatch := func(items []testEvent) {
+		var batch []uint64
+		for _, item := range items {
+			batch = append(batch, item.sequence)
+		}
+		batches = append(batches, batch)
+	}
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator, WithLowWatermark[testEvent](1), WithOnEmitBatch(onEmitBatch))
+	for _, sequence := range []uint64{4, 1, 3, 5, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Flush()
+	require.Equal(t, [][]uint64{{1, 3}, {2, 4}, {5}}, batches)
+	require.Len(t, ch, 5)
+}
+
+func TestExpiry(t *testing.T) {
+	ch := make(chan time.Time, 10)
+	byTime := ComparatorFunc[time.Time](func(a, b time.Time) bool { return a.Before(b) })
+	identity := func(deadline time.Time) time.Time { return deadline }
+	var expired []time.Time
+	onExpired := func(deadline time.Time) { expired = append(expired, deadline) }
+	queue := NewEventQueue[time.Time](2, ch, byTime, WithExpiry(identity, onExpired))
+
+	now := time.Now()
+	queue.Push(now.Add(time.Hour))
+	queue.Push(now.Add(-time.Second))
+	require.Equal(t, []time.Time{now.Add(-time.Second)}, expired)
+	require.Len(t, ch, 0)
+
+	queue.Push(now.Add(-time.Minute))
+	queue.Push(now.Add(2 * time.Hour))
+	require.Equal(t, now.Add(time.Hour), <-ch)
+	queue.Flush()
+	require.Equal(t, now.Add(2*time.Hour), <-ch)
+	require.Len(t, expired, 2)
+	require.Equal(t, uint64(2), queue.Stats().Expired)
+}
+
+func TestMinHold(t *testing.T) {
+	ch := make(chan time.Time, 10)
+	byTime := ComparatorFunc[time.Time](func(a, b time.Time) bool { return a.Before(b) })
+	identity := func(ts time.Time) time.Time { return ts }
+	queue := NewEventQueue[time.Time](10, ch, byTime, WithMinHold(30*time.Millisecond, identity))
+	defer queue.Close()
+
+	now := time.Now()
+	queue.Push(now.Add(-time.Second))
+	require.Equal(t, now.Add(-time.Second), <-ch, "held long enough already")
+
+	queue.Push(now.Add(10 * time.Millisecond))
+	queue.Push(now)
+	select {
+	case <-ch:
+		t.Fatal("event is emitted before its hold time is over")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.Equal(t, now, <-ch)
+	require.Equal(t, now.Add(10*time.Millisecond), <-ch)
+}
+
+func TestStableOrder(t *testing.T) {
+	ch := make(chan testEvent, 20)
+	queue := NewEventQueue[testEvent](4, ch, sequenceComparator, WithStableOrder[testEvent](), WithOwnedOutput[testEvent]())
+	for i := 0; i < 10; i++ {
+		queue.Push(testEvent{sequence: uint64(i % 2), content: i})
+	}
+	snapshot := queue.Snapshot()
+	require.Equal(t, []testEvent{{sequence: 1, content: 5}, {sequence: 1, content: 7}, {sequence: 1, content: 9}}, snapshot)
+	queue.Close()
+
+	var contents []int
+	for item := range ch {
+		contents = append(contents, item.content)
+	}
+	require.Equal(t, []int{0, 2, 4, 6, 1, 8, 3, 5, 7, 9}, contents)
+}
+
+func TestStableOrderRollback(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator, WithStableOrder[testEvent]())
+	queue.Push(testEvent{sequence: 1, content: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, queue.PushContext(ctx, testEvent{sequence: 1, content: 2}), context.DeadlineExceeded)
+	require.False(t, queue.TryPush(testEvent{sequence: 1, content: 3}))
+
+	require.Equal(t, []testEvent{{sequence: 1, content: 1}, {sequence: 1, content: 3}}, queue.Drain())
+}
+
+func TestHoldBounds(t *testing.T) {
+	ch := make(chan time.Time, 10)
+	byTime := ComparatorFunc[time.Time](func(a, b time.Time) bool { return a.Before(b) })
+	identity := func(ts time.Time) time.Time { return ts }
+	queue := NewEventQueue[time.Time](2, ch, byTime, WithHoldBounds(30*time.Millisecond, 60*time.Millisecond, identity))
+	defer queue.Close()
+
+	now := time.Now()
+	queue.Push(now)
+	queue.Push(now.Add(time.Millisecond))
+	select {
+	case <-ch:
+		t.Fatal("event is emitted before minHold")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.Equal(t, now, <-ch, "emitted by emitThreshold once minHold is over")
+	require.Equal(t, now.Add(time.Millisecond), <-ch, "emitted below emitThreshold once maxHold is over")
+	require.GreaterOrEqual(t, time.Since(now), 60*time.Millisecond)
+
+	queue.Push(now.Add(-time.Second))
+	queue.Push(time.Now())
+	require.Equal(t, now.Add(-time.Second), <-ch)
+}
+
+func TestWindowMode(t *testing.T) {
+	ch := make(chan []testEvent, 10)
+	queue := NewBatchEventQueue[testEvent](3, ch, sequenceComparator, WithWindowMode[testEvent](true))
+	require.NoError(t, queue.Restore([]testEvent{{sequence: 5}, {sequence: 4}, {sequence: 6}, {sequence: 7}}))
+
+	queue.Push(testEvent{sequence: 3})
+	require.Equal(t, []testEvent{{sequence: 3}, {sequence: 4}, {sequence: 5}}, <-ch)
+	require.Equal(t, 2, queue.Len(), "a partial window is not emitted")
+
+	queue.Push(testEvent{sequence: 2})
+	require.Equal(t, []testEvent{{sequence: 2}, {sequence: 6}, {sequence: 7}}, <-ch)
+	queue.Push(testEvent{sequence: 1})
+	queue.Flush()
+	require.Equal(t, []testEvent{{sequence: 1}}, <-ch)
+}
+
+func TestOnDrop(t *testing.T) {
+	type drop struct {
+		sequence uint64
+		reason   DropReason
+	}
+	var drops []drop
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator,
+		WithMaxSize[testEvent](2, DropOldest),
+		WithOnDrop(func(item testEvent, reason DropReason) {
+			drops = append(drops, drop{item.sequence, reason})
+		}),
+	)
+
+	for _, sequence := range []uint64{2, 3, 1} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Clear()
+	require.Equal(t, []drop{{2, DropOverflow}, {1, DropCleared}, {3, DropCleared}}, drops)
+	require.Equal(t, uint64(1), queue.Stats().Dropped)
+}
+
+func TestAging(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	aging := func(item testEvent, waited time.Duration) int {
+		if waited >= 20*time.Millisecond {
+			return -1
+		}
+		return int(item.sequence)
+	}
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator, WithAging[testEvent](aging))
+	queue.Push(testEvent{sequence: 5})
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, uint64(1), (<-ch).sequence)
+
+	time.Sleep(30 * time.Millisecond)
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, uint64(5), (<-ch).sequence, "the starving event should be emitted")
+	queue.Push(testEvent{sequence: 2})
+	require.Equal(t, uint64(1), (<-ch).sequence)
+}
+
+func TestComparatorRecovery(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	panicking := ComparatorFunc[testEvent](func(a, b testEvent) bool {
+		if a.content < 0 || b.content < 0 {
+			panic("bad event")
+		}
+		return a.sequence < b.sequence
+	})
+	var errs []error
+	onPanic := func(err error) { errs = append(errs, err) }
+	queue := NewEventQueue[testEvent](10, ch, panicking, WithComparatorRecovery[testEvent](onPanic))
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1, content: -1})
+	require.NotEmpty(t, errs)
+	require.ErrorIs(t, errs[0], ErrComparatorPanic)
+
+	// the lock is released, so the queue keeps working
+	queue.Push(testEvent{sequence: 3})
+	require.Equal(t, 3, queue.Len())
+	queue.Close()
+	require.Len(t, ch, 3)
+}
+
+func TestBlockingBounds(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator,
+		WithBlockingBounds[testEvent](3, 1), WithBackgroundEmitter[testEvent](), WithOwnedOutput[testEvent]())
+
+	var pushed atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 6; i++ {
+			queue.Push(testEvent{sequence: uint64(i)})
+			pushed.Add(1)
+		}
+	}()
+
+	// nobody reads the channel, so the producer stops at the high watermark
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, int64(3), pushed.Load())
+	require.False(t, queue.TryPush(testEvent{sequence: 10}))
+
+	for i := 0; i < 6; i++ {
+		require.Equal(t, uint64(i), (<-ch).sequence)
+	}
+	<-done
+
+	// the producer blocked at shutdown gets ErrClosed
+	for i := 0; i < 3; i++ {
+		queue.Push(testEvent{sequence: uint64(i)})
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- queue.PushErr(testEvent{sequence: 3}) }()
+	time.Sleep(10 * time.Millisecond)
+	go queue.Close()
+	// reading earlier could unblock the push before Close gets the lock
+	require.Eventually(t, func() bool { return queue.Status().Closed }, time.Second, time.Millisecond)
+	for range ch {
+	}
+	require.Equal(t, ErrClosed, <-blocked)
+}
+
+func TestMonotonicEmission(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	var late []uint64
+	onLate := func(item testEvent) { late = append(late, item.sequence) }
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator, WithMonotonicEmission[testEvent](onLate))
+
+	for _, sequence := range []uint64{3, 5} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, uint64(3), (<-ch).sequence)
+
+	require.Equal(t, ErrLate, queue.PushErr(testEvent{sequence: 1}))
+	require.False(t, queue.TryPush(testEvent{sequence: 2}))
+	queue.PushAll([]testEvent{{sequence: 0}, {sequence: 3}, {sequence: 4}})
+	require.Equal(t, []uint64{1, 2, 0}, late)
+	require.Equal(t, uint64(3), queue.Stats().Late)
+
+	queue.Flush()
+	for _, expected := range []uint64{3, 4, 5} {
+		require.Equal(t, expected, (<-ch).sequence)
+	}
+
+	var dropped []DropReason
+	onDrop := func(item testEvent, reason DropReason) { dropped = append(dropped, reason) }
+	queue = NewEventQueue[testEvent](1, ch, sequenceComparator, WithMonotonicEmission[testEvent](nil), WithOnDrop[testEvent](onDrop))
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, []DropReason{DropLate}, dropped)
+	require.Equal(t, uint64(1), queue.Stats().Dropped)
+}
+
+func TestSizer(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	sizer := func(item testEvent) int { return item.content }
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator, WithSizer[testEvent](sizer))
+	queue.Push(testEvent{sequence: 2, content: 20})
+	queue.Push(testEvent{sequence: 1, content: 10})
+	require.Equal(t, 30, queue.SizeBytes())
+
+	queue.Push(testEvent{sequence: 3, content: 30})
+	require.Equal(t, testEvent{sequence: 1, content: 10}, <-ch)
+	require.Equal(t, 50, queue.SizeBytes())
+
+	queue.Update(func(item testEvent) bool { return item.sequence == 2 }, func(item testEvent) testEvent {
+		item.content = 5
+		return item
+	})
+	require.Equal(t, 35, queue.SizeBytes())
+	require.NoError(t, queue.Restore([]testEvent{{sequence: 4, content: 40}}))
+	require.Equal(t, 40, queue.SizeBytes())
+	queue.Clear()
+	require.Zero(t, queue.SizeBytes())
+}
+
+func TestWeight(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	weigh := func(item testEvent) int { return item.content }
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithWeight[testEvent](weigh))
+	queue.Push(testEvent{sequence: 3, content: 4})
+	queue.Push(testEvent{sequence: 1, content: 5})
+	require.Empty(t, ch)
+	require.Equal(t, 9, queue.Weight())
+
+	// 11 in total, emitting the smallest event gets the weight below 10
+	queue.Push(testEvent{sequence: 2, content: 2})
+	require.Equal(t, testEvent{sequence: 1, content: 5}, <-ch)
+	require.Empty(t, ch)
+	require.Equal(t, 6, queue.Weight())
+
+	// a heavy event may take several events with it
+	require.NoError(t, queue.PushContext(context.Background(), testEvent{sequence: 4, content: 20}))
+	require.Equal(t, testEvent{sequence: 2, content: 2}, <-ch)
+	require.Equal(t, testEvent{sequence: 3, content: 4}, <-ch)
+	require.Equal(t, testEvent{sequence: 4, content: 20}, <-ch)
+	require.Empty(t, ch)
+	require.Zero(t, queue.Weight())
+
+	queue.Push(testEvent{sequence: 5, content: 3})
+	queue.Clear()
+	require.Zero(t, queue.Weight())
+}
+
+func TestDuplicateSequence(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	sequence := func(item testEvent) uint64 { return item.sequence }
+	var duplicates []testEvent
+	onDuplicate := func(item testEvent) { duplicates = append(duplicates, item) }
+
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithDuplicateSequence[testEvent](sequence, onDuplicate, true))
+	queue.PushAll([]testEvent{{sequence: 1}, {sequence: 2}, {sequence: 1, content: 1}})
+	require.True(t, queue.TryPush(testEvent{sequence: 2, content: 1}))
+	require.Equal(t, []testEvent{{sequence: 1, content: 1}, {sequence: 2, content: 1}}, duplicates)
+	require.Equal(t, 2, queue.Len())
+	require.Equal(t, uint64(2), queue.Stats().Duplicates)
+
+	// once the event leaves the queue its sequence number may come again
+	queue.PopN(1)
+	queue.Push(testEvent{sequence: 1, content: 2})
+	require.Len(t, duplicates, 2)
+
+	duplicates = nil
+	queue = NewEventQueue[testEvent](10, ch, sequenceComparator, WithDuplicateSequence[testEvent](sequence, onDuplicate, false))
+	queue.Push(testEvent{sequence: 1})
+	queue.Push(testEvent{sequence: 1, content: 1})
+	require.Equal(t, []testEvent{{sequence: 1, content: 1}}, duplicates)
+	require.Equal(t, 2, queue.Len())
+}
+
+func TestCloseSentinel(t *testing.T) {
+	sentinel := testEvent{sequence: math.MaxUint64}
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithCloseSentinel[testEvent](sentinel), WithOwnedOutput[testEvent]())
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+	queue.Close()
+	queue.Close()
+
+	var emitted []testEvent
+	for item := range ch {
+		emitted = append(emitted, item)
+	}
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, sentinel}, emitted)
+	require.Equal(t, uint64(2), queue.Stats().Emitted)
+
+	batches := make(chan []testEvent, 10)
+	batchQueue := NewBatchEventQueue[testEvent](10, batches, sequenceComparator, WithCloseSentinel[testEvent](sentinel))
+	batchQueue.Push(testEvent{sequence: 1})
+	batchQueue.Close()
+	require.Equal(t, []testEvent{{sequence: 1}}, <-batches)
+	require.Equal(t, []testEvent{sentinel}, <-batches)
+	require.Empty(t, batches)
+}
+
+func TestAdaptiveThreshold(t *testing.T) {
+	a := &adaptive{min: 2, max: 20, base: 10}
+	now := time.Unix(0, 0)
+	arrive := func(n int, interval time.Duration) int {
+		threshold := 0
+		for i := 0; i < n; i++ {
+			now = now.Add(interval)
+			threshold = a.arrive(now)
+		}
+		return threshold
+	}
+	require.Equal(t, 10, arrive(100, time.Second))
+	require.Equal(t, 20, arrive(20, 10*time.Millisecond))
+	require.Less(t, arrive(20, 10*time.Second), 10)
+
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithAdaptiveThreshold[testEvent](2, 20))
+	require.Equal(t, 10, queue.Stats().EmitThreshold)
+	require.NoError(t, queue.SetEmitThreshold(30))
+	require.Equal(t, 20, queue.Stats().EmitThreshold)
+}
+
+func TestHeartbeat(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	beat := testEvent{content: -1}
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithHeartbeat[testEvent](20*time.Millisecond, func() testEvent { return beat }))
+	defer queue.Close()
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, beat, <-ch)
+	require.Equal(t, beat, <-ch, "beats go on while nothing is emitted")
+	require.Equal(t, 2, queue.Len())
+	require.Equal(t, uint64(0), queue.Stats().Emitted)
+
+	queue.Flush()
+	item := <-ch
+	for item == beat {
+		// sent before the flush
+		item = <-ch
+	}
+	require.Equal(t, testEvent{sequence: 1}, item)
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+	require.Equal(t, beat, <-ch)
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	batches := make(chan []testEvent, 10)
+	queue := NewBatchEventQueue[testEvent](1, batches, sequenceComparator, WithCoalesceWindow[testEvent](time.Hour))
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Empty(t, batches)
+	queue.Flush()
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}}, <-batches)
+
+	queue = NewBatchEventQueue[testEvent](1, batches, sequenceComparator, WithCoalesceWindow[testEvent](50*time.Millisecond))
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Eventually(t, func() bool { return len(batches) > 0 }, time.Second, time.Millisecond)
+	var emitted []testEvent
+	for len(emitted) < 3 {
+		emitted = append(emitted, <-batches...)
+	}
+	// the pushes may straddle the end of a window
+	require.ElementsMatch(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}}, emitted)
+	queue.Close()
+
+	// the due events stay buffered until the window ends
+	queue = NewBatchEventQueue[testEvent](1, batches, sequenceComparator, WithCoalesceWindow[testEvent](time.Hour))
+	defer queue.Close()
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, 3, queue.Len())
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}}, queue.Snapshot())
+	queue.Clear()
+	queue.emitCoalesced()
+	require.Empty(t, batches)
+
+	queue.Push(testEvent{sequence: 5})
+	queue.Push(testEvent{sequence: 6})
+	require.Equal(t, []testEvent{{sequence: 5}}, queue.PopN(1))
+	queue.Push(testEvent{sequence: 4})
+	queue.emitCoalesced()
+	require.Equal(t, []testEvent{{sequence: 4}, {sequence: 6}}, <-batches)
+	require.Zero(t, queue.Len())
+}
@@ -0,0 +1,120 @@
+package generic
+
+import "time"
+
+// limiter is a token bucket that limits how fast events are emitted, see WithEmitRate.
+// It keeps the theoretical arrival time of the next event instead of counting tokens.
+// It is used by the emitting goroutine only, so it needs no locking
+type limiter struct {
+	interval time.Duration
+	burst    int
+	next     time.Time
+}
+
+func newLimiter(eventsPerSecond float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		interval: time.Duration(float64(time.Second) / eventsPerSecond),
+		burst:    burst,
+	}
+}
+
+// wait waits until n events may be emitted, or until done is closed.
+// It returns false if done is closed first, then nothing is reserved
+func (l *limiter) wait(n int, done <-chan struct{}) bool {
+	now := time.Now()
+	next := l.next
+	if next.Before(now) {
+		next = now
+	}
+	delay := next.Sub(now) - time.Duration(l.burst-1)*l.interval
+	if delay > 0 {
+		if isDone(done) {
+			return false
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-done:
+			return false
+		}
+	}
+	l.next = next.Add(time.Duration(n) * l.interval)
+	return true
+}
+
+// rateSink throttles another sink. Events are let through one by one, while a batch
+// sink gets its slice at once when the limiter allows all the events of it
+type rateSink[T any] struct {
+	sink    sink[T]
+	limiter *limiter
+	batch   bool
+}
+
+func (s rateSink[T]) send(items []T, done <-chan struct{}) int {
+	if s.batch {
+		if !s.limiter.wait(len(items), done) {
+			return 0
+		}
+		return s.sink.send(items, done)
+	}
+
+	for i := range items {
+		if !s.limiter.wait(1, done) || s.sink.send(items[i:i+1], done) == 0 {
+			return i
+		}
+	}
+	return len(items)
+}
+
+func (s rateSink[T]) close() { s.sink.close() }
+
+// pacedSink lets events through to another sink one per signal of ready, a batch sink
+// gets a whole slice per signal. Once unpaced is closed, the events go through right away
+type pacedSink[T any] struct {
+	sink    sink[T]
+	ready   <-chan struct{}
+	unpaced <-chan struct{}
+	batch   bool
+}
+
+func (s pacedSink[T]) send(items []T, done <-chan struct{}) int {
+	if s.batch {
+		if !s.wait(done) {
+			return 0
+		}
+		return s.sink.send(items, done)
+	}
+
+	for i := range items {
+		if !s.wait(done) || s.sink.send(items[i:i+1], done) == 0 {
+			return i
+		}
+	}
+	return len(items)
+}
+
+// wait waits for a signal of ready, it returns false if done is closed first
+func (s pacedSink[T]) wait(done <-chan struct{}) bool {
+	select {
+	case <-s.ready:
+		return true
+	case <-s.unpaced:
+		return true
+	default:
+	}
+
+	select {
+	case <-s.ready:
+		return true
+	case <-s.unpaced:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+func (s pacedSink[T]) close() { s.sink.close() }
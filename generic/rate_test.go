@@ -0,0 +1,60 @@
+package generic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitRate(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithEmitRate[testEvent](100, 2), WithOwnedOutput[testEvent]())
+	for _, sequence := range []uint64{4, 2, 5, 1, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	start := time.Now()
+	queue.Close()
+	// the burst goes at once, the other 3 events wait 10ms each
+	require.GreaterOrEqual(t, time.Since(start), 25*time.Millisecond)
+
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.sequence)
+	}
+	require.Equal(t, []uint64{1, 2, 3, 4, 5}, emitted)
+}
+
+func TestEmitRateTryPush(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithEmitRate[testEvent](1, 1))
+
+	require.True(t, queue.TryPush(testEvent{sequence: 1}))
+	require.False(t, queue.TryPush(testEvent{sequence: 2}), "the limiter has no tokens left")
+	require.Equal(t, 1, queue.Len())
+	require.Len(t, ch, 1)
+}
+
+func TestPacingChannel(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	ready := make(chan struct{}, 1)
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator, WithPacingChannel[testEvent](ready), WithOwnedOutput[testEvent]())
+	ready <- struct{}{}
+	queue.Push(testEvent{sequence: 3})
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+
+	// no credit left
+	require.False(t, queue.TryPush(testEvent{sequence: 2}))
+	require.Empty(t, ch)
+	require.Equal(t, 2, queue.Len())
+
+	// Close does not wait for credits
+	queue.Close()
+	var emitted []testEvent
+	for item := range ch {
+		emitted = append(emitted, item)
+	}
+	require.Equal(t, []testEvent{{sequence: 2}, {sequence: 3}}, emitted)
+}
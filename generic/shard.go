@@ -0,0 +1,79 @@
+package generic
+
+import "sync"
+
+// shard buffers pushed events under its own lock, so that concurrent pushes
+// do not contend on EventQueue lock, see WithShards. The events are kept unsorted
+// until they are merged to the heap
+type shard[T any] struct {
+	lock   sync.Mutex
+	items  []T
+	closed bool
+}
+
+// shardable tells whether pushes may skip EventQueue lock. The options that check
+// an event against the buffered ones when it is pushed need the lock
+func (es *EventQueue[T]) shardable() bool {
+	return es.dedup == nil && es.seenKey == nil && es.duplicateSequence == nil && !es.monotonic && !es.reorder &&
+		es.maxSize <= 0 && es.maxBytes <= 0 && es.blockHigh <= 0 && es.timestamp == nil &&
+		es.adaptive == nil && es.watermarks == nil && es.immediate == nil
+}
+
+// lockMerged acquires es.lock and moves the events buffered by the shards to the heap,
+// so the caller sees all the pushed events
+func (es *EventQueue[T]) lockMerged() {
+	es.lock.Lock()
+	es.mergeShardsUnprotected()
+}
+
+// mergeShardsUnprotected moves the events buffered by the shards to the heap
+// and returns their number
+func (es *EventQueue[T]) mergeShardsUnprotected() int {
+	merged := 0
+	for i := range es.shards {
+		s := &es.shards[i]
+		s.lock.Lock()
+		items := s.items
+		s.items = nil
+		s.closed = es.closed
+		s.lock.Unlock()
+
+		for _, item := range items {
+			es.pushUnprotected(item)
+		}
+		merged += len(items)
+	}
+	if merged > 0 {
+		es.staged.Add(-int64(merged))
+		es.stats.pushed.Add(uint64(merged))
+	}
+	return merged
+}
+
+// stage pushes an event to a shard. Once the shards hold as many events as there are
+// shards, they are merged to the heap and the due events are emitted
+func (es *EventQueue[T]) stage(item T) (bool, error) {
+	s := &es.shards[es.nextShard.Add(1)%uint64(len(es.shards))]
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return false, ErrClosed
+	}
+	s.items = append(s.items, item)
+	// counted under the shard lock, so that a merge does not discount it earlier
+	staged := es.staged.Add(1)
+	s.lock.Unlock()
+	es.notifyPush(item)
+
+	if staged < int64(len(es.shards)) {
+		return true, nil
+	}
+	es.lockMerged()
+	defer es.unlock()
+	if !es.closed {
+		// the events merged by other calls are due too
+		es.collectUnprotected(es.bufferedUnprotected())
+		es.emitUnprotected()
+	}
+	return true, nil
+}
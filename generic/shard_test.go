@@ -0,0 +1,75 @@
+package generic
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShards(t *testing.T) {
+	ch := make(chan testEvent, 1000)
+	queue := NewEventQueue[testEvent](100, ch, sequenceComparator, WithShards[testEvent](4), WithOwnedOutput[testEvent]())
+	for i := 9; i >= 0; i-- {
+		queue.Push(testEvent{sequence: uint64(i)})
+	}
+	require.Equal(t, 10, queue.Len())
+	require.Equal(t, testEvent{sequence: 0}, queue.Snapshot()[0])
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				queue.Push(testEvent{sequence: uint64(10 + g*200 + i)})
+			}
+		}(g)
+	}
+	wg.Wait()
+	queue.Close()
+	require.Equal(t, ErrClosed, queue.PushErr(testEvent{}))
+
+	emitted := 0
+	for range ch {
+		emitted++
+	}
+	require.Equal(t, 810, emitted)
+	require.Equal(t, uint64(810), queue.Stats().Pushed)
+}
+
+func TestShardsLag(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithShards[testEvent](4))
+	for i := 1; i <= 3; i++ {
+		queue.Push(testEvent{sequence: uint64(i)})
+	}
+	// the pushes are staged in the shards, nothing is emitted before the 4th one
+	require.Len(t, ch, 0)
+	require.Equal(t, 3, queue.Len())
+
+	queue.Push(testEvent{sequence: 0})
+	for i := 0; i <= 3; i++ {
+		require.Equal(t, testEvent{sequence: uint64(i)}, <-ch)
+	}
+	require.Zero(t, queue.Len())
+}
+
+// BenchmarkContention compares the single lock with the shards under concurrent pushes
+func BenchmarkContention(b *testing.B) {
+	b.Run("single", func(b *testing.B) { benchmarkContention(b) })
+	b.Run("sharded", func(b *testing.B) { benchmarkContention(b, WithShards[int](8)) })
+}
+
+func benchmarkContention(b *testing.B, options ...Option[int]) {
+	less := ComparatorFunc[int](func(a, b int) bool { return a < b })
+	queue := NewEventQueueFunc[int](1000, func(int) {}, less, options...)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			queue.Push(i)
+			i++
+		}
+	})
+	queue.Close()
+}
@@ -0,0 +1,401 @@
+package generic
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// sink delivers emitted events to the client of EventQueue
+type sink[T any] interface {
+	// send delivers the events in order and returns how many of them are delivered
+	// before done is closed. send is called without EventQueue lock held
+	send(items []T, done <-chan struct{}) int
+	// close is called by Close if the queue owns the sink, see WithOwnedOutput
+	close()
+}
+
+// channelSink sends events to a channel one by one
+type channelSink[T any] chan<- T
+
+func (ch channelSink[T]) send(items []T, done <-chan struct{}) int {
+	for i, item := range items {
+		if !sendTo(ch, item, done) {
+			return i
+		}
+	}
+	return len(items)
+}
+
+func (ch channelSink[T]) close() { close(ch) }
+
+// batchSink sends all the events emitted at once to a channel as a single slice
+type batchSink[T any] chan<- []T
+
+func (ch batchSink[T]) send(items []T, done <-chan struct{}) int {
+	if !sendTo(ch, items, done) {
+		return 0
+	}
+	return len(items)
+}
+
+func (ch batchSink[T]) close() { close(ch) }
+
+// funcSink delivers events by calling a function synchronously, one by one.
+// The function is always ready to take an event, so send is never canceled
+type funcSink[T any] func(T)
+
+func (f funcSink[T]) send(items []T, _ <-chan struct{}) int {
+	for _, item := range items {
+		f(item)
+	}
+	return len(items)
+}
+
+func (f funcSink[T]) close() {}
+
+// Sink takes the events emitted by EventQueue, see NewEventQueueSink.
+// TryPut must not block: it either takes the event right away and returns true,
+// or returns false if there is no room for it. TryPut is called by one goroutine
+// at a time, so a single-producer structure fits
+type Sink[T any] interface {
+	TryPut(item T) bool
+}
+
+// trySink delivers events to Sink, it stops at the first event that does not fit
+type trySink[T any] struct {
+	Sink[T]
+}
+
+func (s trySink[T]) send(items []T, _ <-chan struct{}) int {
+	for i, item := range items {
+		if !s.TryPut(item) {
+			return i
+		}
+	}
+	return len(items)
+}
+
+func (s trySink[T]) close() {}
+
+// errFuncSink delivers events by calling a function that may fail, see NewEventQueueErrFunc
+type errFuncSink[T any] struct {
+	fn      func(T) error
+	policy  ErrorPolicy
+	onError func(T, error)
+	// failure is set once StopOnError policy stops the sink
+	failure atomic.Pointer[sinkFailure]
+}
+
+type sinkFailure struct {
+	err error
+}
+
+func (s *errFuncSink[T]) send(items []T, _ <-chan struct{}) int {
+	if s.failure.Load() != nil {
+		return 0
+	}
+	for i, item := range items {
+		err := s.fn(item)
+		if err == nil {
+			continue
+		}
+		if s.onError != nil {
+			s.onError(item, err)
+		}
+		switch s.policy {
+		case DropOnError:
+			continue
+		case StopOnError:
+			s.failure.Store(&sinkFailure{err: err})
+		}
+		return i
+	}
+	return len(items)
+}
+
+func (s *errFuncSink[T]) close() {}
+
+// eventWriter writes encoded events, it is the sink of NewEventQueueWriter.
+// partial is the encoding of the event whose write failed partway and rest is the part
+// of it not written yet, the events are told apart by their encodings. The retry of
+// the event writes the rest only. If another event goes before the retry, the rest is
+// written first, so that the events are not interleaved in the output, and written
+// remembers the event, so that its retry writes nothing
+type eventWriter[T any] struct {
+	w          io.Writer
+	encode     func(T) ([]byte, error)
+	dropFailed bool
+	partial    []byte
+	rest       []byte
+	written    []byte
+}
+
+func (ew *eventWriter[T]) write(item T) error {
+	data, err := ew.encode(item)
+	if err != nil {
+		return err
+	}
+	if ew.written != nil && bytes.Equal(data, ew.written) {
+		ew.written = nil
+		return nil
+	}
+	if ew.partial != nil {
+		if bytes.Equal(data, ew.partial) {
+			return ew.finish()
+		}
+		partial := ew.partial
+		if err := ew.finish(); err != nil {
+			return err
+		}
+		ew.written = partial
+	}
+	ew.partial, ew.rest = data, data
+	return ew.finish()
+}
+
+// finish writes the rest of the partial event
+func (ew *eventWriter[T]) finish() error {
+	for len(ew.rest) > 0 {
+		n, err := ew.w.Write(ew.rest)
+		ew.rest = ew.rest[n:]
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			if ew.dropFailed {
+				ew.partial, ew.rest = nil, nil
+			}
+			return err
+		}
+	}
+	ew.partial, ew.rest = nil, nil
+	return nil
+}
+
+// Err returns the error that stopped emission under StopOnError policy,
+// see NewEventQueueErrFunc and WithErrorPolicy, or the first I/O error of WithDiskSpill.
+// It returns nil otherwise
+func (es *EventQueue[T]) Err() error {
+	if es.spill != nil {
+		es.lock.Lock()
+		err := es.spill.err
+		es.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if es.failure == nil {
+		return nil
+	}
+	if failure := es.failure.Load(); failure != nil {
+		return failure.err
+	}
+	return nil
+}
+
+// fanoutSink delivers events to several sinks in turn. The first sink decides how many
+// events are delivered, the rest get the same events regardless of done,
+// so that all the sinks see the same sequence
+type fanoutSink[T any] []sink[T]
+
+func (sinks fanoutSink[T]) send(items []T, done <-chan struct{}) int {
+	n := sinks[0].send(items, done)
+	consumed := n
+	if first, ok := sinks[0].(*timeoutSink[T]); ok {
+		// the events the first output gave up on still go to the rest
+		consumed += first.given
+	}
+	for _, s := range sinks[1:] {
+		s.send(items[:consumed], nil)
+	}
+	return n
+}
+
+func (sinks fanoutSink[T]) close() {
+	for _, s := range sinks {
+		s.close()
+	}
+}
+
+// routerSink sends each event to the channel picked by route, the events it picks
+// no channel for go to the wrapped sink, see WithRouter
+type routerSink[T any] struct {
+	sink  sink[T]
+	route func(T) chan<- T
+}
+
+func (s routerSink[T]) send(items []T, done <-chan struct{}) int {
+	// the events from start on are not routed, they go to the wrapped sink at once
+	start := 0
+	for i, item := range items {
+		ch := s.route(item)
+		if ch == nil {
+			continue
+		}
+		if start < i {
+			if n := s.sink.send(items[start:i], done); n < i-start {
+				return start + n
+			}
+		}
+		if !sendTo(ch, item, done) {
+			return i
+		}
+		start = i + 1
+	}
+	if start < len(items) {
+		return start + s.sink.send(items[start:], done)
+	}
+	return len(items)
+}
+
+// close closes the wrapped sink only, the routed channels belong to the client
+func (s routerSink[T]) close() { s.sink.close() }
+
+// AddOutput registers one more output channel, so that every event emitted since then
+// is sent to all the outputs in the same order. The outputs are served one after
+// another, so a slow consumer holds back the others. If the send is canceled
+// (see PushContext), an event delivered to the first output is still delivered to the rest.
+// The outputs go through WithRouter, WithEmitRate and WithPacingChannel together,
+// and each channel gets the timeout of WithSendTimeout on its own: an event the first
+// output gives up on is dropped and still sent to the rest, the others skip it.
+// With WithOwnedOutput Close closes the added channels too. A pull-only queue
+// (see NewEventQueue) starts emitting to the added channel.
+// AddOutput does nothing if the queue is closed or outputChannel is nil
+func (es *EventQueue[T]) AddOutput(outputChannel chan<- T) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	if es.closed {
+		return
+	}
+	if es.pull {
+		if outputChannel == nil {
+			return
+		}
+		es.base, es.pull, es.batchOutput = channelSink[T](outputChannel), false, false
+	} else {
+		var sinks fanoutSink[T]
+		if current, ok := es.base.(fanoutSink[T]); ok {
+			// copied, since a send in progress may be using the current one
+			sinks = append(sinks, current...)
+		} else {
+			sinks = append(sinks, es.base)
+		}
+		es.base = append(sinks, channelSink[T](outputChannel))
+	}
+	// a send in progress keeps the current output, the new one is used by the next batch
+	es.wrapOutputUnprotected()
+}
+
+// timeoutSink sends events to a channel like channelSink, or like batchSink if batch
+// is set, but gives up on an event that is not taken within timeout, see WithSendTimeout.
+// It is used by the emitting goroutine only, so that the timer can be reused
+type timeoutSink[T any] struct {
+	ch      chan<- T
+	batch   chan<- []T
+	timeout time.Duration
+	timer   *time.Timer
+	// drop is called for the events given up, if it is nil the events stay in the queue.
+	// send returns the number of events delivered only, drop accounts for the rest
+	drop func(items []T)
+	// given is the number of events given up by the last send
+	given int
+}
+
+func newTimeoutSink[T any](output sink[T], timeout time.Duration, drop func(items []T)) (*timeoutSink[T], bool) {
+	s := &timeoutSink[T]{timeout: timeout, drop: drop}
+	switch ch := output.(type) {
+	case channelSink[T]:
+		s.ch = ch
+	case batchSink[T]:
+		s.batch = ch
+	default:
+		return nil, false
+	}
+	s.timer = time.NewTimer(timeout)
+	stopTimer(s.timer)
+	return s, true
+}
+
+func (s *timeoutSink[T]) send(items []T, done <-chan struct{}) int {
+	s.given = 0
+	if s.batch != nil {
+		if sent, timedOut := sendWithin(s.batch, items, done, s.timer, s.timeout); sent {
+			return len(items)
+		} else if timedOut && s.drop != nil {
+			s.given = len(items)
+			s.drop(items)
+		}
+		return 0
+	}
+
+	delivered := 0
+	for i, item := range items {
+		if sent, timedOut := sendWithin(s.ch, item, done, s.timer, s.timeout); sent {
+			delivered++
+			continue
+		} else if timedOut && s.drop != nil {
+			s.given++
+			s.drop(items[i : i+1])
+			continue
+		}
+		break
+	}
+	return delivered
+}
+
+func (s *timeoutSink[T]) close() {
+	if s.batch != nil {
+		close(s.batch)
+	} else {
+		close(s.ch)
+	}
+}
+
+// sendWithin sends a value to a channel like sendTo does, but also gives up
+// once timer fires after timeout. The timer must be stopped and drained
+func sendWithin[V any](ch chan<- V, value V, done <-chan struct{}, timer *time.Timer, timeout time.Duration) (sent, timedOut bool) {
+	select {
+	case ch <- value:
+		return true, false
+	default:
+	}
+
+	timer.Reset(timeout)
+	select {
+	case ch <- value:
+		stopTimer(timer)
+		return true, false
+	case <-done:
+		stopTimer(timer)
+		return false, false
+	case <-timer.C:
+		return false, true
+	}
+}
+
+// stopTimer stops the timer and drains its channel, so that it can be reset safely
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		<-timer.C
+	}
+}
+
+// sendTo sends a value to a channel unless done is closed first.
+// If the channel is ready, the value is sent even if done is closed already
+func sendTo[V any](ch chan<- V, value V, done <-chan struct{}) bool {
+	select {
+	case ch <- value:
+		return true
+	default:
+	}
+
+	select {
+	case ch <- value:
+		return true
+	case <-done:
+		return false
+	}
+}
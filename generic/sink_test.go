@@ -0,0 +1,329 @@
+package generic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchEventQueue(t *testing.T) {
+	ch := make(chan []testEvent, 10)
+	queue := NewBatchEventQueue[testEvent](3, ch, sequenceComparator, WithLowWatermark[testEvent](1), WithOwnedOutput[testEvent]())
+
+	for _, sequence := range []uint64{4, 1, 3, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 3}}, <-ch)
+	require.Len(t, ch, 0)
+
+	queue.Push(testEvent{sequence: 5})
+	require.Equal(t, []testEvent{{sequence: 2}, {sequence: 4}}, <-ch)
+	queue.Push(testEvent{sequence: 6})
+	queue.Close()
+	require.Equal(t, []testEvent{{sequence: 5}, {sequence: 6}}, <-ch)
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+func TestBatchEventQueueSorted(t *testing.T) {
+	ch := make(chan []testEvent)
+	queue := NewBatchEventQueue[testEvent](1, ch, sequenceComparator, WithEmitAll[testEvent]())
+
+	go queue.Push(testEvent{sequence: 5})
+	waitEmitting(queue)
+	// popped while the first batch waits for the consumer, 6 after 7
+	queue.Push(testEvent{sequence: 7})
+	queue.Push(testEvent{sequence: 6})
+	require.Equal(t, []testEvent{{sequence: 5}}, <-ch)
+	require.Equal(t, []testEvent{{sequence: 6}, {sequence: 7}}, <-ch)
+
+	queue = NewBatchEventQueue[testEvent](10, ch, sequenceComparator)
+	for _, sequence := range []uint64{4, 1, 3, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	go queue.Flush()
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}, {sequence: 4}}, <-ch)
+}
+
+func TestBatchEventQueueCanceled(t *testing.T) {
+	ch := make(chan []testEvent)
+	queue := NewBatchEventQueue[testEvent](2, ch, sequenceComparator, WithLowWatermark[testEvent](0))
+
+	queue.Push(testEvent{sequence: 2})
+	require.False(t, queue.TryPush(testEvent{sequence: 1}))
+	require.Equal(t, 2, queue.Len())
+}
+
+func TestEventQueueFunc(t *testing.T) {
+	var emitted []uint64
+	queue := NewEventQueueFunc[testEvent](3, func(item testEvent) {
+		emitted = append(emitted, item.sequence)
+	}, sequenceComparator)
+
+	for _, sequence := range []uint64{4, 2, 5, 1, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, []uint64{2, 1, 3}, emitted)
+
+	queue.Close()
+	require.Equal(t, []uint64{2, 1, 3, 4, 5}, emitted)
+}
+
+func TestAddOutput(t *testing.T) {
+	first := make(chan testEvent, 10)
+	second := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](2, first, sequenceComparator, WithOwnedOutput[testEvent]())
+
+	queue.Push(testEvent{sequence: 2})
+	queue.Push(testEvent{sequence: 1})
+	queue.AddOutput(second)
+	queue.Push(testEvent{sequence: 3})
+	queue.Close()
+
+	var emitted [][]uint64
+	for _, ch := range []chan testEvent{first, second} {
+		var sequences []uint64
+		for item := range ch {
+			sequences = append(sequences, item.sequence)
+		}
+		emitted = append(emitted, sequences)
+	}
+	require.Equal(t, [][]uint64{{1, 2, 3}, {2, 3}}, emitted)
+}
+
+func TestAddOutputWrapped(t *testing.T) {
+	routed := make(chan testEvent, 10)
+	var dropped []uint64
+	queue := NewEventQueue[testEvent](1, nil, sequenceComparator,
+		WithRouter(func(item testEvent) chan<- testEvent {
+			if item.sequence%2 == 0 {
+				return routed
+			}
+			return nil
+		}),
+		WithSendTimeout[testEvent](10*time.Millisecond, true),
+		WithOnDrop(func(item testEvent, reason DropReason) { dropped = append(dropped, item.sequence) }),
+	)
+	first := make(chan testEvent, 1)
+	queue.AddOutput(first)
+	for sequence := uint64(1); sequence <= 4; sequence++ {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, testEvent{sequence: 1}, <-first)
+	require.Equal(t, []uint64{3}, dropped, "the added output times out")
+	require.Equal(t, testEvent{sequence: 2}, <-routed)
+	require.Equal(t, testEvent{sequence: 4}, <-routed)
+
+	// an added output that does not take an event in time skips it
+	second := make(chan testEvent)
+	queue.AddOutput(second)
+	queue.Push(testEvent{sequence: 5})
+	require.Equal(t, testEvent{sequence: 5}, <-first)
+	require.Equal(t, []uint64{3}, dropped)
+	require.Equal(t, uint64(4), queue.Stats().Emitted)
+	require.Equal(t, 0, queue.Len())
+}
+
+func TestEventQueueErrFunc(t *testing.T) {
+	errSink := errors.New("sink failed")
+	for _, tc := range []struct {
+		policy   ErrorPolicy
+		emitted  []uint64
+		left     []uint64
+		stopped  bool
+		failures int
+	}{
+		{policy: RetryOnError, emitted: []uint64{1, 2, 3, 4}, failures: 1},
+		{policy: DropOnError, emitted: []uint64{1, 3, 4}, failures: 1},
+		{policy: StopOnError, emitted: []uint64{1}, left: []uint64{2, 3, 4}, stopped: true, failures: 1},
+	} {
+		var emitted []uint64
+		failures := 0
+		failed := false
+		queue := NewEventQueueErrFunc[testEvent](2, func(item testEvent) error {
+			if item.sequence == 2 && !failed {
+				failed = true
+				return errSink
+			}
+			emitted = append(emitted, item.sequence)
+			return nil
+		}, sequenceComparator, WithErrorPolicy(tc.policy, func(item testEvent, err error) {
+			require.Equal(t, errSink, err)
+			failures++
+		}))
+
+		for _, sequence := range []uint64{1, 2, 3, 4} {
+			queue.Push(testEvent{sequence: sequence})
+		}
+		queue.Flush()
+
+		require.Equal(t, tc.emitted, emitted, tc.policy)
+		require.Equal(t, tc.failures, failures, tc.policy)
+		var left []uint64
+		for _, item := range queue.Drain() {
+			left = append(left, item.sequence)
+		}
+		require.Equal(t, tc.left, left, tc.policy)
+		if tc.stopped {
+			require.Equal(t, errSink, queue.Err())
+		} else {
+			require.NoError(t, queue.Err())
+		}
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	ch := make(chan testEvent)
+	queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithSendTimeout[testEvent](10*time.Millisecond, false))
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, 1, queue.Len(), "the event stays in the queue")
+
+	var dropped []uint64
+	queue = NewEventQueue[testEvent](1, ch, sequenceComparator,
+		WithSendTimeout[testEvent](10*time.Millisecond, true),
+		WithOnDrop(func(item testEvent, reason DropReason) {
+			require.Equal(t, DropTimeout, reason)
+			dropped = append(dropped, item.sequence)
+		}),
+	)
+	queue.Push(testEvent{sequence: 1})
+	queue.Push(testEvent{sequence: 2})
+	require.Equal(t, []uint64{1, 2}, dropped)
+	require.Equal(t, uint64(2), queue.Stats().Dropped)
+	require.Equal(t, uint64(0), queue.Stats().Emitted, "given up events are not emitted")
+	require.Equal(t, uint64(0), queue.Status().TotalEmitted)
+	require.True(t, queue.Status().LastEmitAt.IsZero())
+
+	go queue.Push(testEvent{sequence: 3})
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Eventually(t, func() bool { return queue.Stats().Emitted == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, 0, queue.Len())
+}
+
+// ringBuffer is a bounded Sink for tests
+type ringBuffer struct {
+	items []uint64
+	size  int
+}
+
+func (r *ringBuffer) TryPut(item testEvent) bool {
+	if len(r.items) == r.size {
+		return false
+	}
+	r.items = append(r.items, item.sequence)
+	return true
+}
+
+func TestEventQueueSink(t *testing.T) {
+	ring := &ringBuffer{size: 2}
+	queue := NewEventQueueSink[testEvent](2, ring, sequenceComparator)
+	for _, sequence := range []uint64{4, 2, 5, 1} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, []uint64{2, 4}, ring.items)
+	require.Equal(t, 2, queue.Len())
+
+	// the ring is full, so the events stay in the queue
+	queue.Push(testEvent{sequence: 3})
+	require.Equal(t, 3, queue.Len())
+
+	ring.items = nil
+	queue.Flush()
+	require.Equal(t, []uint64{1, 3}, ring.items)
+	require.Equal(t, 1, queue.Len())
+}
+
+func TestRouter(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	tenants := []chan testEvent{make(chan testEvent, 10), make(chan testEvent, 10)}
+	route := func(item testEvent) chan<- testEvent {
+		if item.content == 0 {
+			return nil
+		}
+		return tenants[item.content-1]
+	}
+
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithRouter[testEvent](route), WithOwnedOutput[testEvent]())
+	for i, content := range []int{0, 1, 2, 1, 0, 2} {
+		queue.Push(testEvent{sequence: uint64(6 - i), content: content})
+	}
+	queue.Close()
+
+	var emitted []testEvent
+	for item := range ch {
+		emitted = append(emitted, item)
+	}
+	require.Equal(t, []testEvent{{sequence: 2, content: 0}, {sequence: 6, content: 0}}, emitted)
+	require.Equal(t, testEvent{sequence: 3, content: 1}, <-tenants[0])
+	require.Equal(t, testEvent{sequence: 5, content: 1}, <-tenants[0])
+	require.Equal(t, testEvent{sequence: 1, content: 2}, <-tenants[1])
+	require.Equal(t, testEvent{sequence: 4, content: 2}, <-tenants[1])
+	require.Equal(t, uint64(6), queue.Stats().Emitted)
+}
+
+// flakyWriter takes up to limit bytes per write, the writes beyond failAfter bytes fail once
+type flakyWriter struct {
+	bytes.Buffer
+	limit     int
+	failAfter int
+	failed    bool
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		p = p[:w.limit]
+	}
+	if !w.failed && w.Len()+len(p) > w.failAfter {
+		w.failed = true
+		n, _ := w.Buffer.Write(p[:w.failAfter-w.Len()])
+		return n, errors.New("flaky")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestEventQueueWriterRetryBehind(t *testing.T) {
+	w := &flakyWriter{limit: 100, failAfter: 3}
+	encode := func(item testEvent) ([]byte, error) {
+		return []byte(fmt.Sprintf("event %d\n", item.sequence)), nil
+	}
+	queue := NewEventQueueWriter[testEvent](1, w, encode, sequenceComparator, WithErrorPolicy[testEvent](RetryOnError, nil))
+
+	queue.Push(testEvent{sequence: 5})
+	require.Equal(t, "eve", w.String())
+
+	// 3 goes before the retry of 5, which is finished first
+	queue.Push(testEvent{sequence: 3})
+	require.Equal(t, "event 5\nevent 3\n", w.String())
+	require.Equal(t, 1, queue.Len())
+
+	// the retry of 5 has nothing left to write
+	queue.Flush()
+	require.Equal(t, "event 5\nevent 3\n", w.String())
+	require.Zero(t, queue.Len())
+}
+
+func TestEventQueueWriter(t *testing.T) {
+	w := &flakyWriter{limit: 4, failAfter: 6}
+	encode := func(item testEvent) ([]byte, error) {
+		return []byte(fmt.Sprintf("event %d\n", item.sequence)), nil
+	}
+	var failures int
+	onError := func(testEvent, error) { failures++ }
+
+	queue := NewEventQueueWriter[testEvent](10, w, encode, sequenceComparator, WithErrorPolicy[testEvent](RetryOnError, onError))
+	for _, sequence := range []uint64{2, 3, 1} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.Flush()
+	require.Equal(t, 1, failures)
+	require.Equal(t, "event ", w.String())
+	require.Equal(t, 3, queue.Len())
+
+	queue.Flush()
+	require.Equal(t, "event 1\nevent 2\nevent 3\n", w.String())
+	require.Zero(t, queue.Len())
+}
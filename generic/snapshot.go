@@ -0,0 +1,104 @@
+package generic
+
+// Snapshot returns a copy of the buffered events in sorted order like SortedSnapshot,
+// so that the caller can persist them and rebuild the queue later with Restore.
+// The queue is not changed. Events being sent at the moment are not included
+func (es *EventQueue[T]) Snapshot() []T {
+	return es.SortedSnapshot()
+}
+
+// SortedSnapshot returns a sorted copy of the buffered events taken atomically under
+// the lock, e.g. to dump the buffer on a diagnostics endpoint. Unlike Drain it removes
+// nothing, unlike ForEach it returns a materialized slice. The copy holds the events
+// counted by Len: the spilled ones (see WithDiskSpill) are read back for it and the due
+// ones of WithCoalesceWindow are included, the events being sent at the moment are not.
+// SortedSnapshot copies the events and sorts them, so it costs O(n log n)
+func (es *EventQueue[T]) SortedSnapshot() []T {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.unspillUnprotected()
+	defer es.spillUnprotected()
+	return es.queue.sorted()
+}
+
+// ForEach calls fn for the buffered events in sorted order until fn returns false.
+// It walks a point-in-time copy taken like Snapshot does, so it costs O(n log n),
+// and fn is called with the lock released, so it may call into the queue.
+// The queue is not changed
+func (es *EventQueue[T]) ForEach(fn func(T) bool) {
+	for _, item := range es.Snapshot() {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+// Restore replaces the buffered events with items, e.g. the ones saved by Snapshot.
+// The heap is rebuilt in O(n), items does not need to be sorted and is not retained.
+// Restore does not emit anything, events are emitted by the next pushes or Flush.
+// The replaced events are reported to the OnDrop hook as cleared.
+// ErrClosed is returned if the queue is closed
+func (es *EventQueue[T]) Restore(items []T) error {
+	es.lockMerged()
+	defer es.unlock()
+
+	if es.closed {
+		return ErrClosed
+	}
+	es.clearUnprotected()
+	es.loadUnprotected(items)
+	return nil
+}
+
+// loadUnprotected adds items to the queue at once, heapifying in O(n)
+// instead of pushing them one by one
+func (es *EventQueue[T]) loadUnprotected(items []T) {
+	es.queue.load(items)
+	es.lenChangedUnprotected()
+	if es.sizer != nil {
+		for _, item := range items {
+			es.sizeBytes += es.sizer(item)
+		}
+	}
+	if es.weigher != nil {
+		for _, item := range items {
+			es.weight += es.weigher(item)
+		}
+	}
+	if es.dedup != nil {
+		for _, item := range items {
+			es.keys[es.dedup(item)] = struct{}{}
+		}
+	}
+	for _, item := range items {
+		es.countSequenceUnprotected(item, 1)
+	}
+	es.spillUnprotected()
+	if es.timestamp != nil {
+		es.holdUnprotected()
+	}
+}
+
+// unloadUnprotected undoes loadUnprotected for events taken out of the heap at once
+func (es *EventQueue[T]) unloadUnprotected(items []T) {
+	es.lenChangedUnprotected()
+	if es.maxSize > 0 || es.maxBytes > 0 || es.blockHigh > 0 {
+		// wake up pushes waiting for room
+		es.idle.Broadcast()
+	}
+	for _, item := range items {
+		if es.sizer != nil {
+			es.sizeBytes -= es.sizer(item)
+		}
+		es.weight -= es.weightUnprotected(item)
+		es.countSequenceUnprotected(item, -1)
+		if es.dedup != nil {
+			delete(es.keys, es.dedup(item))
+		}
+	}
+	es.spillUnprotected()
+	if es.timestamp != nil {
+		es.holdUnprotected()
+	}
+}
@@ -0,0 +1,82 @@
+package generic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 4, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	snapshot := queue.Snapshot()
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}, {sequence: 4}}, snapshot)
+	require.Equal(t, 4, queue.Len())
+
+	restored := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	restored.Push(testEvent{sequence: 10})
+	require.NoError(t, restored.Restore([]testEvent{{sequence: 4}, {sequence: 2}, {sequence: 3}, {sequence: 1}}))
+	require.Equal(t, 4, restored.Len())
+	require.Equal(t, snapshot, restored.PopN(4))
+
+	restored.Close()
+	require.Equal(t, ErrClosed, restored.Restore(snapshot))
+}
+
+func TestSortedSnapshot(t *testing.T) {
+	less := ComparatorFunc[int](func(a, b int) bool { return a < b })
+	spilled := NewEventQueue[int](100, nil, less, WithDiskSpill[int](t.TempDir(), 2))
+	for _, item := range []int{5, 3, 6, 1, 4, 2} {
+		spilled.Push(item)
+	}
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6}, spilled.SortedSnapshot())
+	require.Equal(t, 6, spilled.Len(), "nothing is removed")
+
+	batches := make(chan []testEvent, 10)
+	coalesced := NewBatchEventQueue[testEvent](1, batches, sequenceComparator, WithCoalesceWindow[testEvent](time.Hour))
+	for _, sequence := range []uint64{3, 1, 2} {
+		coalesced.Push(testEvent{sequence: sequence})
+	}
+	// the due events wait for the window to end
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}}, coalesced.SortedSnapshot())
+	require.Empty(t, batches)
+	coalesced.emitCoalesced()
+	require.Equal(t, []testEvent{{sequence: 1}, {sequence: 2}, {sequence: 3}}, <-batches)
+}
+
+func TestForEach(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 4, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	var visited []uint64
+	queue.ForEach(func(item testEvent) bool {
+		visited = append(visited, item.sequence)
+		return item.sequence < 3
+	})
+	require.Equal(t, []uint64{1, 2, 3}, visited)
+	require.Equal(t, 4, queue.Len())
+}
+
+func TestClone(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator, WithReverse[testEvent](true))
+	for _, sequence := range []uint64{1, 3} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	cloned := make(chan testEvent, 10)
+	clone := queue.Clone(cloned)
+	clone.Push(testEvent{sequence: 2})
+	require.Equal(t, testEvent{sequence: 3}, <-cloned)
+	require.Len(t, ch, 0)
+	require.Equal(t, []testEvent{{sequence: 3}, {sequence: 1}}, queue.Snapshot())
+	require.Equal(t, []testEvent{{sequence: 2}, {sequence: 1}}, clone.Snapshot())
+}
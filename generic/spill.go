@@ -0,0 +1,209 @@
+package generic
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spill keeps the largest buffered events on disk once the heap grows beyond maxMem,
+// see WithDiskSpill. Each spill writes a run of sorted events to a file of its own.
+// The heap always holds the smallest buffered events: once its smallest event does not
+// sort before the smallest spilled one, the smallest spilled events are read back,
+// merging the runs. The spilled events are still counted as buffered, so sizes,
+// weights and keys of the options are kept for them
+type spill[T any] struct {
+	dir    string
+	maxMem int
+	runs   []*spillRun[T]
+	// count is the number of spilled events not read back yet
+	count int
+	// err is the first I/O error, see Err
+	err error
+}
+
+// spillRun is a file of sorted events, head is the next event to read back
+type spillRun[T any] struct {
+	file    *os.File
+	decoder *gob.Decoder
+	head    T
+	// left is the number of events not read back yet, head included
+	left int
+}
+
+// bufferedUnprotected returns the number of buffered events, spilled ones included
+func (es *EventQueue[T]) bufferedUnprotected() int {
+	if es.spill == nil {
+		return es.queue.Len()
+	}
+	return es.queue.Len() + es.spill.count
+}
+
+// spillUnprotected writes the largest half of the heap to disk once the heap holds
+// more than maxMem events. If writing fails, the events stay in the heap
+func (es *EventQueue[T]) spillUnprotected() {
+	sp := es.spill
+	if sp == nil || es.queue.Len() <= sp.maxMem || sp.err != nil {
+		return
+	}
+
+	// a sorted heap is a heap too, so the kept half needs no fixing
+	es.queue.sort()
+	kept := sp.maxMem / 2
+	if kept < 1 {
+		kept = 1
+	}
+	run, err := writeRun(sp.dir, es.queue.data[kept:])
+	if err != nil {
+		sp.err = err
+		return
+	}
+	sp.runs = append(sp.runs, run)
+	sp.count += run.left
+
+	var zero T
+	for i := kept; i < len(es.queue.data); i++ {
+		es.queue.unindex(i)
+		es.queue.data[i] = zero
+	}
+	es.queue.data = es.queue.data[:kept]
+	if es.queue.tracked() {
+		es.queue.meta = es.queue.meta[:kept]
+	}
+}
+
+// pageInUnprotected reads the smallest spilled events back once the smallest event
+// of the heap does not sort before them, so it must be called whenever the smallest
+// event of the heap changes for a larger one. The events are read until the heap
+// holds maxMem events, one at least
+func (es *EventQueue[T]) pageInUnprotected() {
+	sp := es.spill
+	if sp == nil || sp.count == 0 {
+		return
+	}
+	if next := sp.next(es.queue.comparator); es.queue.Len() > 0 && es.queue.comparator.Less(es.queue.data[0], next.head) {
+		return
+	}
+
+	for read := 0; sp.count > 0 && (read == 0 || es.queue.Len() < sp.maxMem); read++ {
+		es.readSpilledUnprotected()
+	}
+}
+
+// unspillUnprotected reads all the spilled events back to the heap, for the methods
+// that look at every buffered event or reorder them. The caller spills the heap again
+// with spillUnprotected once it is done, so that it does not stay above maxMem
+func (es *EventQueue[T]) unspillUnprotected() {
+	sp := es.spill
+	if sp == nil {
+		return
+	}
+	for sp.count > 0 {
+		es.readSpilledUnprotected()
+	}
+}
+
+// readSpilledUnprotected moves the smallest spilled event to the heap
+func (es *EventQueue[T]) readSpilledUnprotected() {
+	sp := es.spill
+	run := sp.next(es.queue.comparator)
+	es.queue.push(run.head)
+	sp.count--
+	if err := run.advance(); err != nil {
+		// the rest of the run is lost
+		sp.err = err
+		sp.count -= run.left
+		es.stats.dropped.Add(uint64(run.left))
+		run.left = 0
+	}
+	if run.left == 0 {
+		sp.remove(run)
+	}
+}
+
+// next returns the run with the smallest head
+func (sp *spill[T]) next(comparator Comparator[T]) *spillRun[T] {
+	next := sp.runs[0]
+	for _, run := range sp.runs[1:] {
+		if comparator.Less(run.head, next.head) {
+			next = run
+		}
+	}
+	return next
+}
+
+// remove deletes the file of a run read back
+func (sp *spill[T]) remove(run *spillRun[T]) {
+	for i, r := range sp.runs {
+		if r == run {
+			sp.runs = append(sp.runs[:i], sp.runs[i+1:]...)
+			break
+		}
+	}
+	run.close()
+}
+
+// clear deletes all the runs, e.g. on Clear
+func (sp *spill[T]) clear() {
+	for _, run := range sp.runs {
+		run.close()
+	}
+	sp.runs = nil
+	sp.count = 0
+}
+
+// writeRun writes sorted events to a new file in dir and opens it for reading back
+func writeRun[T any](dir string, items []T) (*spillRun[T], error) {
+	file, err := os.CreateTemp(dir, "eventqueue-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("spill events: %w", err)
+	}
+	run := &spillRun[T]{file: file, left: len(items)}
+
+	w := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(w)
+	for i := range items {
+		// encoded through a pointer, so that interface events keep their concrete types
+		if err = encoder.Encode(&items[i]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		run.close()
+		return nil, fmt.Errorf("spill events: %w", err)
+	}
+
+	run.decoder = gob.NewDecoder(bufio.NewReader(file))
+	if err := run.decoder.Decode(&run.head); err != nil {
+		run.close()
+		return nil, fmt.Errorf("spill events: %w", err)
+	}
+	return run, nil
+}
+
+// advance reads the next head of the run unless the run is read back
+func (run *spillRun[T]) advance() error {
+	run.left--
+	var zero T
+	run.head = zero
+	if run.left == 0 {
+		return nil
+	}
+	if err := run.decoder.Decode(&run.head); err != nil {
+		return fmt.Errorf("read spilled events: %w", err)
+	}
+	return nil
+}
+
+func (run *spillRun[T]) close() {
+	run.file.Close()
+	os.Remove(run.file.Name())
+}
@@ -0,0 +1,90 @@
+package generic
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskSpill(t *testing.T) {
+	dir := t.TempDir()
+	ch := make(chan int, 1000)
+	less := ComparatorFunc[int](func(a, b int) bool { return a < b })
+	queue := NewEventQueue[int](300, ch, less, WithDiskSpill[int](dir, 16))
+
+	pushed := rand.Perm(1000)
+	for _, item := range pushed {
+		queue.Push(item)
+	}
+	require.Equal(t, 300-1, queue.Len())
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	queue.Flush()
+	require.NoError(t, queue.Err())
+	emitted := make([]int, 0, len(pushed))
+	for len(ch) > 0 {
+		emitted = append(emitted, <-ch)
+	}
+	// the events are emitted as if they were all in memory
+	reference := make(chan int, 1000)
+	inMemory := NewEventQueue[int](300, reference, less)
+	for _, item := range pushed {
+		inMemory.Push(item)
+	}
+	inMemory.Flush()
+	for _, item := range emitted {
+		require.Equal(t, <-reference, item)
+	}
+	require.Len(t, emitted, len(pushed))
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	drained := NewEventQueue[int](10000, nil, less, WithDiskSpill[int](dir, 16))
+	for _, item := range pushed {
+		drained.Push(item)
+	}
+	items := drained.Drain()
+	require.Len(t, items, len(pushed))
+	require.True(t, sort.IntsAreSorted(items))
+}
+
+func TestDiskSpillSeesAllEvents(t *testing.T) {
+	less := ComparatorFunc[int](func(a, b int) bool { return a < b })
+	dir := t.TempDir()
+	queue := NewEventQueue[int](100, nil, less, WithDiskSpill[int](dir, 4))
+	for _, item := range rand.Perm(10) {
+		queue.Push(item + 1)
+	}
+	require.Equal(t, 10, queue.Len())
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	snapshot := queue.Snapshot()
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, snapshot)
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, files, "spilled again after the snapshot")
+	require.Equal(t, snapshot, queue.Clone(nil).Drain())
+	require.Equal(t, 5, queue.CountIf(func(item int) bool { return item%2 == 0 }))
+	require.True(t, queue.Contains(func(item int) bool { return item == 10 }))
+	min, max, ok := queue.Bounds()
+	require.True(t, ok)
+	require.Equal(t, []int{1, 10}, []int{min, max})
+
+	require.True(t, queue.Update(func(item int) bool { return item == 9 }, func(int) int { return 0 }))
+	removed, ok := queue.Remove(func(item int) bool { return item == 10 })
+	require.True(t, ok)
+	require.Equal(t, 10, removed)
+
+	// the spilled runs are sorted again by the new comparator
+	queue.SetComparator(ComparatorFunc[int](func(a, b int) bool { return a > b }))
+	require.Equal(t, []int{8, 7, 6, 5, 4, 3, 2, 1, 0}, queue.Drain())
+	require.NoError(t, queue.Err())
+}
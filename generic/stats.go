@@ -0,0 +1,176 @@
+package generic
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds runtime metrics of EventQueue, see EventQueue.Stats
+type Stats struct {
+	// Pushed is the total number of events accepted by the queue
+	Pushed uint64
+	// Emitted is the total number of events delivered to output
+	Emitted uint64
+	// Len is the current number of buffered events
+	Len int
+	// PeakLen is the largest number of events buffered at once
+	PeakLen int
+	// Flushes is the number of flushes done by Flush or WithFlushInterval
+	Flushes uint64
+	// Dropped is the number of events dropped because the queue was full (see WithMaxSize)
+	// or the consumer did not take them in time (see WithSendTimeout)
+	Dropped uint64
+	// Duplicates is the number of pushed events with the sequence number of a buffered
+	// event, see WithDuplicateSequence
+	Duplicates uint64
+	// Expired is the number of events that expired before they were emitted, see WithExpiry
+	Expired uint64
+	// EmitThreshold is the current emitThreshold, it changes with WithAdaptiveThreshold
+	// and SetEmitThreshold
+	EmitThreshold int
+
+	// The reordering metrics are collected with WithReorderStats only.
+	// The reorder distance of an event is the number of buffered events that sort
+	// after it when it arrives, i.e. how many events it overtakes.
+	// ReorderMax is the largest reorder distance, ReorderAvg is the average one
+	ReorderMax int
+	ReorderAvg float64
+	// Late is the number of events that arrived after an event sorting after them
+	// was emitted already, so they are emitted out of order. Late events mean
+	// that emitThreshold is too small for the actual disorder.
+	// With WithMonotonicEmission late events are counted too, though they are rejected
+	Late uint64
+}
+
+// Status is the lifecycle state of EventQueue, see EventQueue.Status
+type Status struct {
+	// Buffered is the number of events in the queue, not counting the ones being sent
+	Buffered int
+	// TotalEmitted is the total number of events delivered to output
+	TotalEmitted uint64
+	// Closed tells whether the queue is closed
+	Closed bool
+	// LastEmitAt is the time events were delivered to output last time,
+	// it is zero if nothing has been emitted yet
+	LastEmitAt time.Time
+}
+
+// stats keeps the counters of Stats. They are updated under EventQueue lock,
+// but read atomically, so reading them does not wait for the lock
+type stats struct {
+	pushed     atomic.Uint64
+	emitted    atomic.Uint64
+	len        atomic.Int64
+	peakLen    atomic.Int64
+	flushes    atomic.Uint64
+	dropped    atomic.Uint64
+	duplicates atomic.Uint64
+	expired    atomic.Uint64
+	threshold  atomic.Int64
+
+	reorderMax   atomic.Int64
+	reorderSum   atomic.Uint64
+	reorderCount atomic.Uint64
+	late         atomic.Uint64
+}
+
+func (s *stats) setLen(n int) {
+	s.len.Store(int64(n))
+	if int64(n) > s.peakLen.Load() {
+		s.peakLen.Store(int64(n))
+	}
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Pushed:     s.pushed.Load(),
+		Emitted:    s.emitted.Load(),
+		Len:        int(s.len.Load()),
+		PeakLen:    int(s.peakLen.Load()),
+		Flushes:    s.flushes.Load(),
+		Dropped:    s.dropped.Load(),
+		Duplicates: s.duplicates.Load(),
+		Expired:    s.expired.Load(),
+
+		EmitThreshold: int(s.threshold.Load()),
+
+		ReorderMax: int(s.reorderMax.Load()),
+		ReorderAvg: s.reorderAvg(),
+		Late:       s.late.Load(),
+	}
+}
+
+func (s *stats) reorderAvg() float64 {
+	count := s.reorderCount.Load()
+	if count == 0 {
+		return 0
+	}
+	return float64(s.reorderSum.Load()) / float64(count)
+}
+
+// observeUnprotected collects the reordering metrics of an arriving event,
+// see WithReorderStats. It scans the queue, so it costs O(n).
+// The arrival rate of WithAdaptiveThreshold is tracked here too
+func (es *EventQueue[T]) observeUnprotected(item T) {
+	es.adaptUnprotected()
+	if !es.reorder {
+		return
+	}
+	if es.emittedAny && es.queue.comparator.Less(item, es.lastEmitted) {
+		es.stats.late.Add(1)
+	}
+
+	distance := 0
+	for _, buffered := range es.queue.data {
+		if es.queue.comparator.Less(item, buffered) {
+			distance++
+		}
+	}
+	if int64(distance) > es.stats.reorderMax.Load() {
+		es.stats.reorderMax.Store(int64(distance))
+	}
+	es.stats.reorderSum.Add(uint64(distance))
+	es.stats.reorderCount.Add(1)
+}
+
+// Stats returns runtime metrics of the queue. It does not take the queue lock,
+// so it never waits for pushes. Each counter is read atomically, but the counters
+// are not read all at once, so they may be slightly inconsistent with each other
+func (es *EventQueue[T]) Stats() Stats { return es.stats.snapshot() }
+
+// Status returns the lifecycle state of the queue. Unlike Stats, it reads all
+// the fields under the queue lock, so they are consistent with each other
+func (es *EventQueue[T]) Status() Status {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return Status{
+		Buffered:     es.bufferedUnprotected(),
+		TotalEmitted: es.stats.emitted.Load(),
+		Closed:       es.closed,
+		LastEmitAt:   es.lastEmitAt,
+	}
+}
+
+// LenCap returns the number of buffered events and the capacity of the buffer
+// holding them, read at once, so they can be compared to see the slack of the buffer.
+// Events being sent at the moment are not counted
+func (es *EventQueue[T]) LenCap() (length, capacity int) {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	return es.bufferedUnprotected(), cap(es.queue.data)
+}
+
+// PeakLen returns the largest number of events the queue has buffered at once
+// since it was created or since the last ResetPeak. It helps to see whether
+// emitThreshold fits the actual bursts. Like Stats, it does not take the queue lock
+func (es *EventQueue[T]) PeakLen() int { return int(es.stats.peakLen.Load()) }
+
+// ResetPeak resets PeakLen to the current length of the queue
+func (es *EventQueue[T]) ResetPeak() {
+	es.lockMerged()
+	defer es.lock.Unlock()
+
+	es.stats.peakLen.Store(es.stats.len.Load())
+}
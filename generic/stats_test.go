@@ -0,0 +1,114 @@
+package generic
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator)
+
+	for _, sequence := range []uint64{4, 1, 3, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, Stats{Pushed: 4, Emitted: 2, Len: 2, PeakLen: 3, EmitThreshold: 3}, queue.Stats())
+
+	queue.Flush()
+	require.Equal(t, Stats{Pushed: 4, Emitted: 4, Len: 0, PeakLen: 3, Flushes: 1, EmitThreshold: 3}, queue.Stats())
+}
+
+func TestLenDoesNotRace(t *testing.T) {
+	ch := make(chan int, 1000)
+	queue := NewEventQueue[int](10, ch, ComparatorFunc[int](func(a, b int) bool { return a < b }))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				queue.Push(g*100 + i)
+			}
+		}(g)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			require.True(t, queue.Len() <= 10)
+		}
+	}
+	require.Equal(t, 9, queue.Len())
+}
+
+func TestPeakLen(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator)
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	queue.PopN(2)
+	require.Equal(t, 3, queue.PeakLen())
+
+	queue.ResetPeak()
+	require.Equal(t, 1, queue.PeakLen())
+	queue.Push(testEvent{sequence: 4})
+	require.Equal(t, 2, queue.PeakLen())
+}
+
+func TestReorderStats(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](3, ch, sequenceComparator, WithReorderStats[testEvent]())
+	for _, sequence := range []uint64{3, 4, 1, 5, 2, 6} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+
+	stats := queue.Stats()
+	// 1 overtakes 3 and 4, 2 overtakes 4 and 5, but 3 is emitted already
+	require.Equal(t, 2, stats.ReorderMax)
+	require.InDelta(t, 4.0/6, stats.ReorderAvg, 1e-9)
+	require.Equal(t, uint64(1), stats.Late)
+}
+
+func TestStatus(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator)
+	require.Equal(t, Status{}, queue.Status())
+
+	before := time.Now()
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	status := queue.Status()
+	require.Equal(t, 1, status.Buffered)
+	require.Equal(t, uint64(2), status.TotalEmitted)
+	require.False(t, status.Closed)
+	require.False(t, status.LastEmitAt.Before(before))
+
+	queue.Close()
+	status = queue.Status()
+	require.Equal(t, 0, status.Buffered)
+	require.Equal(t, uint64(3), status.TotalEmitted)
+	require.True(t, status.Closed)
+}
+
+func TestLenCap(t *testing.T) {
+	ch := make(chan testEvent, 10)
+	queue := NewEventQueue[testEvent](10, ch, sequenceComparator, WithInitialCapacity[testEvent](4))
+	for _, sequence := range []uint64{3, 1, 2} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	length, capacity := queue.LenCap()
+	require.Equal(t, 3, length)
+	require.Equal(t, 4, capacity)
+}
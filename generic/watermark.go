@@ -0,0 +1,97 @@
+package generic
+
+// watermarks tracks the sequence up to which the events are delivered, see WithWatermark.
+// Like the signals of backpressure, the watermark is set under EventQueue lock and sent
+// by a dedicated goroutine, so a slow consumer of the watermarks never holds back the
+// events. Only the latest watermark is kept, the ones the consumer did not take in time
+// are skipped
+type watermarks[T any] struct {
+	ch       chan<- uint64
+	sequence SequenceFunc[T]
+
+	// highest is the largest sequence delivered so far
+	highest   uint64
+	delivered bool
+	// last is the last watermark set, next is the one waiting to be sent
+	last    uint64
+	hasLast bool
+	next    uint64
+	hasNext bool
+	wake    chan struct{}
+	// done is closed by Close, so that a send the consumer does not take is given up
+	done chan struct{}
+}
+
+// watermarkUnprotected advances the watermark after the first n events of batch are
+// delivered. The watermark is the sequence right before the smallest undelivered event,
+// or the largest delivered sequence once nothing is left to deliver
+func (es *EventQueue[T]) watermarkUnprotected(batch []T, n int) {
+	w := es.watermarks
+	if w == nil || n == 0 {
+		return
+	}
+	for _, item := range batch[:n] {
+		if s := w.sequence(item); !w.delivered || s > w.highest {
+			w.highest, w.delivered = s, true
+		}
+	}
+
+	mark, undelivered := w.highest, false
+	lowest := func(item T) {
+		if s := w.sequence(item); !undelivered || s < mark {
+			mark, undelivered = s, true
+		}
+	}
+	for _, item := range batch[n:] {
+		lowest(item)
+	}
+	// the events popped meanwhile are not in order, see sendUnprotected
+	for _, item := range es.pending {
+		lowest(item)
+	}
+	if es.queue.Len() > 0 {
+		lowest(es.queue.data[0])
+	}
+	if undelivered {
+		if mark == 0 {
+			return
+		}
+		mark--
+	}
+
+	if w.hasLast && mark <= w.last {
+		return
+	}
+	w.last, w.hasLast = mark, true
+	w.next, w.hasNext = mark, true
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signalWatermarks is the goroutine that sends the watermarks of WithWatermark.
+// It runs until Close closes wake and done. A watermark the consumer is ready for
+// is sent even after Close, e.g. the last one, the rest are given up
+func (es *EventQueue[T]) signalWatermarks() {
+	w := es.watermarks
+	for range w.wake {
+		es.lock.Lock()
+		mark, ok := w.next, w.hasNext
+		w.hasNext = false
+		es.lock.Unlock()
+
+		if !ok {
+			continue
+		}
+		select {
+		case w.ch <- mark:
+		default:
+			select {
+			case w.ch <- mark:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
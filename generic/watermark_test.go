@@ -0,0 +1,53 @@
+package generic
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatermark(t *testing.T) {
+	marks := make(chan uint64, 10)
+	ch := make(chan testEvent, 10)
+	sequence := func(item testEvent) uint64 { return item.sequence }
+	queue := NewEventQueue[testEvent](2, ch, sequenceComparator, WithWatermark[testEvent](marks, sequence))
+
+	queue.Push(testEvent{sequence: 1})
+	queue.Push(testEvent{sequence: 3})
+	require.Equal(t, testEvent{sequence: 1}, <-ch)
+	require.Equal(t, uint64(2), <-marks, "3 is not delivered yet")
+
+	queue.Push(testEvent{sequence: 5})
+	require.Equal(t, testEvent{sequence: 3}, <-ch)
+	require.Equal(t, uint64(4), <-marks)
+
+	// a late event is delivered, but the watermark does not move back
+	queue.Push(testEvent{sequence: 2})
+	require.Equal(t, testEvent{sequence: 2}, <-ch)
+
+	queue.Close()
+	require.Equal(t, testEvent{sequence: 5}, <-ch)
+	require.Equal(t, uint64(5), <-marks)
+	require.Len(t, marks, 0)
+}
+
+func TestWatermarkAfterClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	sequence := func(item testEvent) uint64 { return item.sequence }
+	for i := 0; i < 20; i++ {
+		// nobody takes the watermarks
+		marks := make(chan uint64)
+		ch := make(chan testEvent, 10)
+		queue := NewEventQueue[testEvent](1, ch, sequenceComparator, WithWatermark[testEvent](marks, sequence))
+		queue.Push(testEvent{sequence: 1})
+		queue.Push(testEvent{sequence: 2})
+		queue.Close()
+	}
+	// not require.Eventually, it runs the condition on a goroutine of its own
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before, "the goroutines sending watermarks stop with the queues")
+}
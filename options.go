@@ -0,0 +1,307 @@
+package eventqueue
+
+import (
+	"time"
+
+	"github.com/elgris/eventqueue/generic"
+)
+
+// Option configures optional behavior of EventQueue, see NewEventQueue
+type Option = generic.Option[interface{}]
+
+// WithInitialCapacity preallocates the buffer of the queue for initialCapacity events,
+// see generic.WithInitialCapacity
+func WithInitialCapacity(initialCapacity int) Option {
+	return generic.WithInitialCapacity[interface{}](initialCapacity)
+}
+
+// WithBufferReuse keeps and reuses the buffers of the queue to reduce garbage,
+// see generic.WithBufferReuse
+func WithBufferReuse() Option {
+	return generic.WithBufferReuse[interface{}]()
+}
+
+// WithOwnedOutput transfers ownership of the output channel to EventQueue,
+// see generic.WithOwnedOutput
+func WithOwnedOutput() Option { return generic.WithOwnedOutput[interface{}]() }
+
+// WithAdaptiveThreshold makes the queue tune emitThreshold to the arrival rate
+// within [min, max], see generic.WithAdaptiveThreshold
+func WithAdaptiveThreshold(min, max int) Option {
+	return generic.WithAdaptiveThreshold[interface{}](min, max)
+}
+
+// WithFlushComparator makes the queue sort the flushed events by comparator instead
+// of the comparator of the queue, see generic.WithFlushComparator
+func WithFlushComparator(comparator Comparator) Option {
+	return generic.WithFlushComparator[interface{}](comparator)
+}
+
+// WithCloseSentinel makes Close send sentinel to the output channel once the queue
+// is drained, see generic.WithCloseSentinel
+func WithCloseSentinel(sentinel interface{}) Option {
+	return generic.WithCloseSentinel[interface{}](sentinel)
+}
+
+// WithRouter makes the queue send each emitted event to the channel picked by route,
+// see generic.WithRouter
+func WithRouter(route func(item interface{}) chan<- interface{}) Option {
+	return generic.WithRouter[interface{}](route)
+}
+
+// WithLowWatermark makes the queue drain down to lowWatermark events each time
+// emitThreshold is hit, see generic.WithLowWatermark
+func WithLowWatermark(lowWatermark int) Option {
+	return generic.WithLowWatermark[interface{}](lowWatermark)
+}
+
+// WithEmitAll makes the queue emit all the buffered events each time emitThreshold
+// is hit, see generic.WithEmitAll
+func WithEmitAll() Option {
+	return generic.WithEmitAll[interface{}]()
+}
+
+// WithWindowMode makes emission strictly window-aligned, see generic.WithWindowMode
+func WithWindowMode(window bool) Option {
+	return generic.WithWindowMode[interface{}](window)
+}
+
+// WithImmediate makes a push of an urgent event emit the smallest buffered event
+// right away, see generic.WithImmediate
+func WithImmediate(urgent func(item interface{}) bool) Option {
+	return generic.WithImmediate[interface{}](urgent)
+}
+
+// WithManualEmit turns off automatic emission, see generic.WithManualEmit
+func WithManualEmit(manual bool) Option {
+	return generic.WithManualEmit[interface{}](manual)
+}
+
+// WithFlushInterval makes the queue flush itself if no event is emitted within
+// flushInterval, see generic.WithFlushInterval
+func WithFlushInterval(flushInterval time.Duration) Option {
+	return generic.WithFlushInterval[interface{}](flushInterval)
+}
+
+// WithCoalesceWindow makes the queue send the events due for emission at the end
+// of fixed time windows, see generic.WithCoalesceWindow
+func WithCoalesceWindow(window time.Duration) Option {
+	return generic.WithCoalesceWindow[interface{}](window)
+}
+
+// WithSequence gates emission on contiguity of event sequence numbers,
+// see generic.WithSequence
+func WithSequence(sequence SequenceFunc, first uint64) Option {
+	return generic.WithSequence[interface{}](generic.SequenceFunc[interface{}](sequence), first)
+}
+
+// WithWatermark sends to watermarkChannel the sequence up to which all the events
+// are delivered, see generic.WithWatermark
+func WithWatermark(watermarkChannel chan<- uint64, sequence SequenceFunc) Option {
+	return generic.WithWatermark[interface{}](watermarkChannel, generic.SequenceFunc[interface{}](sequence))
+}
+
+// WithHeartbeat sends a beat made by makeBeat once no event is emitted for interval,
+// see generic.WithHeartbeat
+func WithHeartbeat(interval time.Duration, makeBeat func() interface{}) Option {
+	return generic.WithHeartbeat[interface{}](interval, makeBeat)
+}
+
+// WithDedup drops events whose key is buffered already, see generic.WithDedup
+func WithDedup(keyFunc func(interface{}) string) Option {
+	return generic.WithDedup[interface{}](keyFunc)
+}
+
+// WithDedupTTL drops events whose key is pushed less than ttl ago, see generic.WithDedupTTL
+func WithDedupTTL(keyFunc func(interface{}) string, ttl time.Duration) Option {
+	return generic.WithDedupTTL[interface{}](keyFunc, ttl)
+}
+
+// WithDuplicateSequence reports pushed events with the sequence number of a buffered
+// event to onDuplicate and drops them if drop is true, see generic.WithDuplicateSequence
+func WithDuplicateSequence(sequence SequenceFunc, onDuplicate func(item interface{}), drop bool) Option {
+	return generic.WithDuplicateSequence[interface{}](generic.SequenceFunc[interface{}](sequence), onDuplicate, drop)
+}
+
+// WithMinHold makes the queue emit an event once it has been held for minHold
+// since its own timestamp, see generic.WithMinHold
+func WithMinHold(minHold time.Duration, timestamp func(interface{}) time.Time) Option {
+	return generic.WithMinHold[interface{}](minHold, timestamp)
+}
+
+// WithStableOrder makes events that are equal by the comparator leave the queue
+// in the order they were pushed, see generic.WithStableOrder
+func WithStableOrder() Option {
+	return generic.WithStableOrder[interface{}]()
+}
+
+// WithComparatorRecovery makes the queue survive a comparator that panics,
+// see generic.WithComparatorRecovery
+func WithComparatorRecovery(onPanic func(err error)) Option {
+	return generic.WithComparatorRecovery[interface{}](onPanic)
+}
+
+// WithAging makes events that wait in the queue gain priority, so low priority
+// events can't be starved, see generic.WithAging
+func WithAging(aging func(base interface{}, waited time.Duration) int) Option {
+	return generic.WithAging[interface{}](aging)
+}
+
+// WithHoldBounds bounds how long an event stays in the queue, counting from its own
+// timestamp, see generic.WithHoldBounds
+func WithHoldBounds(minHold, maxHold time.Duration, timestamp func(interface{}) time.Time) Option {
+	return generic.WithHoldBounds[interface{}](minHold, maxHold, timestamp)
+}
+
+// WithReverse makes the queue emit events in descending order, see generic.WithReverse
+func WithReverse(reverse bool) Option {
+	return generic.WithReverse[interface{}](reverse)
+}
+
+// WithOnPush sets a hook called after an event is added to the queue, see generic.WithOnPush
+func WithOnPush(hook func(interface{})) Option {
+	return generic.WithOnPush[interface{}](hook)
+}
+
+// WithOnEmit sets a hook called just before an event is emitted, see generic.WithOnEmit
+func WithOnEmit(hook func(interface{})) Option {
+	return generic.WithOnEmit[interface{}](hook)
+}
+
+// WithExpiry passes the events whose deadline has passed before they are emitted
+// to onExpired instead of output channel, see generic.WithExpiry
+func WithExpiry(deadline func(item interface{}) time.Time, onExpired func(item interface{})) Option {
+	return generic.WithExpiry[interface{}](deadline, onExpired)
+}
+
+// WithOnEmitBatch sets a hook called once per batch of events emitted at once,
+// see generic.WithOnEmitBatch
+func WithOnEmitBatch(hook func([]interface{})) Option {
+	return generic.WithOnEmitBatch[interface{}](hook)
+}
+
+// WithPacingChannel makes the queue send the events due for emission one per signal
+// of ready, see generic.WithPacingChannel
+func WithPacingChannel(ready <-chan struct{}) Option {
+	return generic.WithPacingChannel[interface{}](ready)
+}
+
+// WithEmitRate limits emission to eventsPerSecond events per second, allowing bursts
+// of up to burst events, see generic.WithEmitRate
+func WithEmitRate(eventsPerSecond float64, burst int) Option {
+	return generic.WithEmitRate[interface{}](eventsPerSecond, burst)
+}
+
+// WithBackgroundEmitter makes a dedicated goroutine send the events emitted by Push,
+// see generic.WithBackgroundEmitter
+func WithBackgroundEmitter() Option {
+	return generic.WithBackgroundEmitter[interface{}]()
+}
+
+// WithShards spreads concurrent pushes over n shards with their own locks,
+// see generic.WithShards
+func WithShards(n int) Option {
+	return generic.WithShards[interface{}](n)
+}
+
+// WithBackpressure signals the producers that the queue grows too long,
+// see generic.WithBackpressure
+func WithBackpressure(softLimit int, onHigh, onLow func(curLen int)) Option {
+	return generic.WithBackpressure[interface{}](softLimit, onHigh, onLow)
+}
+
+// WithReorderStats makes the queue measure how far out of order the events arrive,
+// see generic.WithReorderStats
+func WithReorderStats() Option {
+	return generic.WithReorderStats[interface{}]()
+}
+
+// WithOnDrop sets a hook called for every event the queue discards, see generic.WithOnDrop
+func WithOnDrop(hook func(item interface{}, reason DropReason)) Option {
+	return generic.WithOnDrop[interface{}](hook)
+}
+
+// DropReason tells why an event is discarded, see WithOnDrop
+type DropReason = generic.DropReason
+
+// Drop reasons, see generic.DropReason
+const (
+	DropOverflow = generic.DropOverflow
+	DropCleared  = generic.DropCleared
+	DropTimeout  = generic.DropTimeout
+	DropLate     = generic.DropLate
+)
+
+// ErrorPolicy tells what a queue created by NewEventQueueErrFunc does when its sink fails,
+// see generic.ErrorPolicy
+type ErrorPolicy = generic.ErrorPolicy
+
+// Error policies, see generic.ErrorPolicy
+const (
+	RetryOnError = generic.RetryOnError
+	DropOnError  = generic.DropOnError
+	StopOnError  = generic.StopOnError
+)
+
+// WithErrorPolicy sets what happens when the sink of NewEventQueueErrFunc fails,
+// see generic.WithErrorPolicy
+func WithErrorPolicy(policy ErrorPolicy, onError func(item interface{}, err error)) Option {
+	return generic.WithErrorPolicy[interface{}](policy, onError)
+}
+
+// WithSendTimeout makes the queue give up on an event that output channel does not
+// take within timeout, see generic.WithSendTimeout
+func WithSendTimeout(timeout time.Duration, drop bool) Option {
+	return generic.WithSendTimeout[interface{}](timeout, drop)
+}
+
+// OverflowPolicy tells what a bounded queue does when it is full, see WithMaxSize
+type OverflowPolicy = generic.OverflowPolicy
+
+// Overflow policies, see generic.OverflowPolicy
+const (
+	DropNewest = generic.DropNewest
+	DropOldest = generic.DropOldest
+	Block      = generic.Block
+)
+
+// WithBlockingBounds makes pushes block once high events are undelivered until
+// there are low of them left, see generic.WithBlockingBounds
+func WithBlockingBounds(high, low int) Option {
+	return generic.WithBlockingBounds[interface{}](high, low)
+}
+
+// WithMonotonicEmission rejects the events that sort before the last emitted event
+// and diverts them to onLate, see generic.WithMonotonicEmission
+func WithMonotonicEmission(onLate func(item interface{})) Option {
+	return generic.WithMonotonicEmission[interface{}](onLate)
+}
+
+// WithSizer makes the queue keep the total size in bytes of the buffered events,
+// see generic.WithSizer and EventQueue.SizeBytes
+func WithSizer(sizer func(item interface{}) int) Option {
+	return generic.WithSizer[interface{}](sizer)
+}
+
+// WithWeight makes emitThreshold apply to the total weight of the buffered events,
+// see generic.WithWeight and EventQueue.Weight
+func WithWeight(weigh func(item interface{}) int) Option {
+	return generic.WithWeight[interface{}](weigh)
+}
+
+// WithDiskSpill keeps the largest events in temporary files in dir once the heap
+// holds more than maxMem events, see generic.WithDiskSpill
+func WithDiskSpill(dir string, maxMem int) Option {
+	return generic.WithDiskSpill[interface{}](dir, maxMem)
+}
+
+// WithMaxSize bounds the queue to maxSize buffered events, see generic.WithMaxSize
+func WithMaxSize(maxSize int, policy OverflowPolicy) Option {
+	return generic.WithMaxSize[interface{}](maxSize, policy)
+}
+
+// WithMaxBytes bounds the total size of the buffered events as estimated by WithSizer,
+// see generic.WithMaxBytes
+func WithMaxBytes(maxBytes int, policy OverflowPolicy) Option {
+	return generic.WithMaxBytes[interface{}](maxBytes, policy)
+}
@@ -0,0 +1,65 @@
+package eventqueue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(3, ch, sequenceComparator,
+		WithInitialCapacity(3),
+		WithLowWatermark(0),
+		WithOwnedOutput(),
+	)
+
+	for _, sequence := range []uint64{3, 1, 2, 4} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, 1, queue.Len())
+	queue.Close()
+
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.(testEvent).sequence)
+	}
+	require.Equal(t, []uint64{1, 2, 3, 4}, emitted)
+}
+
+func TestSequenceGaps(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	sequence := func(item interface{}) uint64 { return item.(testEvent).sequence }
+	queue := NewEventQueue(2, ch, sequenceComparator, WithSequence(sequence, 1), WithOwnedOutput())
+
+	queue.Push(testEvent{sequence: 3})
+	queue.Push(testEvent{sequence: 2})
+	require.Len(t, ch, 0, "event #1 is missing")
+
+	queue.Push(testEvent{sequence: 1})
+	require.Equal(t, 1, queue.Len())
+	queue.Push(testEvent{sequence: 5})
+	queue.Push(testEvent{sequence: 6})
+	require.Equal(t, 2, queue.Len(), "event #4 is missing")
+	queue.Close()
+
+	var emitted []uint64
+	for item := range ch {
+		emitted = append(emitted, item.(testEvent).sequence)
+	}
+	require.Equal(t, []uint64{1, 2, 3, 5, 6}, emitted)
+}
+
+func TestEmitAll(t *testing.T) {
+	ch := make(chan interface{}, 10)
+	queue := NewEventQueue(3, ch, sequenceComparator, WithEmitAll())
+
+	for _, sequence := range []uint64{3, 1, 2, 5} {
+		queue.Push(testEvent{sequence: sequence})
+	}
+	require.Equal(t, 1, queue.Len())
+	for _, expected := range []uint64{1, 2, 3} {
+		require.Equal(t, expected, (<-ch).(testEvent).sequence)
+	}
+	require.Len(t, ch, 0)
+}